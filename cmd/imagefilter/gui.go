@@ -0,0 +1,16 @@
+//go:build !headless
+
+package main
+
+import (
+	"image-filter-editor/internal/gui"
+
+	"fyne.io/fyne/v2/app"
+)
+
+func runGUI() {
+	a := app.NewWithID("computer-graphics.imagefilter")
+	window := gui.NewMainWindow(a)
+	window.Show()
+	a.Run()
+}
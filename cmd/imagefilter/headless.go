@@ -0,0 +1,15 @@
+//go:build headless
+
+package main
+
+import (
+	"os"
+
+	"image-filter-editor/internal/cli"
+)
+
+// Headless builds (go build -tags headless) do not link Fyne at all,
+// so without a subcommand there is nothing to do but print the usage.
+func runGUI() {
+	os.Exit(cli.Run(nil, os.Stdout, os.Stderr))
+}
@@ -1,14 +1,14 @@
 package main
 
 import (
-	"image-filter-editor/internal/gui"
+	"os"
 
-	"fyne.io/fyne/v2/app"
+	"image-filter-editor/internal/cli"
 )
 
 func main() {
-    a := app.NewWithID("computer-graphics.imagefilter")
-    window := gui.NewMainWindow(a)
-    window.Show()
-    a.Run()
-}
\ No newline at end of file
+	if len(os.Args) > 1 && cli.IsCommand(os.Args[1]) {
+		os.Exit(cli.Run(os.Args[1:], os.Stdout, os.Stderr))
+	}
+	runGUI()
+}
@@ -0,0 +1,264 @@
+// Package cli implements the headless subcommands of imagefilter. It must
+// not import anything GUI related so it can run on machines without a display.
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"image/color"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"image-filter-editor/internal/filters"
+	"image-filter-editor/internal/pipeline"
+	"image-filter-editor/internal/utils"
+)
+
+const usage = `Usage:
+  imagefilter                       start the graphical editor
+  imagefilter apply [flags] [files] apply filters or a preset to images
+  imagefilter filters               list available filters and parameters
+  imagefilter bench [flags]         time filters on a synthetic image
+
+Presets (-preset look.json or look.yaml) are saved from the editor and run
+before any -f filters.
+
+Filter specs (-f, repeatable, applied in order):
+  gaussian                  filter with default parameters
+  gamma=1.8                 set the filter's first parameter
+  dither:levels=4,size=4    set named parameters
+`
+
+var commands = map[string]func(args []string, stdout, stderr io.Writer) int{
+	"apply":   runApply,
+	"filters": runFilters,
+}
+
+// IsCommand reports whether arg selects a headless subcommand.
+func IsCommand(arg string) bool {
+	switch arg {
+	case "help", "-h", "-help", "--help":
+		return true
+	}
+	_, ok := commands[arg]
+	return ok
+}
+
+// Run executes a subcommand and returns the process exit code.
+func Run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		if IsCommand(args[0]) {
+			fmt.Fprint(stdout, usage)
+			return 0
+		}
+		fmt.Fprintf(stderr, "unknown command %q\n\n%s", args[0], usage)
+		return 2
+	}
+	return cmd(args[1:], stdout, stderr)
+}
+
+type listFlag []string
+
+func (l *listFlag) String() string     { return strings.Join(*l, ",") }
+func (l *listFlag) Set(v string) error { *l = append(*l, v); return nil }
+
+func runApply(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("apply", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var inputs, specs listFlag
+	fs.Var(&inputs, "in", "input file, directory or glob (repeatable)")
+	fs.Var(&specs, "f", "filter spec (repeatable)")
+	presetPath := fs.String("preset", "", "JSON or YAML preset applied before any -f filters")
+	out := fs.String("out", "", "output file, or directory when there are several inputs")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	inputs = append(inputs, fs.Args()...)
+
+	if len(inputs) == 0 || *out == "" {
+		fmt.Fprintln(stderr, "apply: -in and -out are required")
+		fs.Usage()
+		return 2
+	}
+
+	stack := &pipeline.Stack{}
+	if *presetPath != "" {
+		preset, err := pipeline.LoadPreset(*presetPath)
+		if err == nil {
+			stack, err = preset.Stack()
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "apply: %v\n", err)
+			return 2
+		}
+	}
+	for _, spec := range specs {
+		step, err := pipeline.ParseStep(spec)
+		if err != nil {
+			fmt.Fprintf(stderr, "apply: %v\n", err)
+			return 2
+		}
+		stack.Steps = append(stack.Steps, step)
+	}
+
+	files, err := expandInputs(inputs)
+	if err != nil {
+		fmt.Fprintf(stderr, "apply: %v\n", err)
+		return 1
+	}
+
+	toDir := len(files) > 1 || strings.HasSuffix(*out, string(os.PathSeparator)) || isDir(*out)
+	// Inputs from different directories can share a name, check before
+	// writing anything rather than overwrite one result with another.
+	dsts := make([]string, len(files))
+	from := make(map[string]string)
+	for i, in := range files {
+		dsts[i] = *out
+		if toDir {
+			dsts[i] = filepath.Join(*out, outputName(in))
+		}
+		if prev, dup := from[dsts[i]]; dup {
+			fmt.Fprintf(stderr, "apply: %s and %s would both be written to %s\n", prev, in, dsts[i])
+			return 1
+		}
+		from[dsts[i]] = in
+	}
+
+	if toDir {
+		if err := os.MkdirAll(*out, 0o755); err != nil {
+			fmt.Fprintf(stderr, "apply: %v\n", err)
+			return 1
+		}
+	}
+
+	failed := false
+	for i, in := range files {
+		dst := dsts[i]
+		if err := processFile(stack, in, dst); err != nil {
+			fmt.Fprintf(stderr, "apply: %v\n", err)
+			failed = true
+			continue
+		}
+		fmt.Fprintf(stdout, "%s -> %s\n", in, dst)
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+func processFile(stack *pipeline.Stack, in, out string) error {
+	img, err := utils.LoadImage(in)
+	if err != nil {
+		return err
+	}
+	result, err := stack.Render(utils.ToRGBA(img))
+	if err != nil {
+		return fmt.Errorf("%s: %w", in, err)
+	}
+	return utils.SaveImage(out, result)
+}
+
+// expandInputs resolves globs and directories into a sorted list of image files.
+func expandInputs(inputs []string) ([]string, error) {
+	var files []string
+	seen := make(map[string]bool)
+	add := func(path string) {
+		if !seen[path] {
+			seen[path] = true
+			files = append(files, path)
+		}
+	}
+
+	for _, in := range inputs {
+		matches := []string{in}
+		if strings.ContainsAny(in, "*?[") {
+			var err error
+			matches, err = filepath.Glob(in)
+			if err != nil {
+				return nil, fmt.Errorf("bad pattern %q: %w", in, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no files match %q", in)
+			}
+		}
+		for _, m := range matches {
+			info, err := os.Stat(m)
+			if err != nil {
+				return nil, err
+			}
+			if !info.IsDir() {
+				add(m)
+				continue
+			}
+			entries, err := os.ReadDir(m)
+			if err != nil {
+				return nil, err
+			}
+			for _, e := range entries {
+				if !e.IsDir() && utils.IsImageFile(e.Name()) {
+					add(filepath.Join(m, e.Name()))
+				}
+			}
+		}
+	}
+
+	if len(files) == 0 {
+		return nil, errors.New("no input images found")
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func outputName(in string) string {
+	name := filepath.Base(in)
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".png", ".jpg", ".jpeg":
+		return name
+	}
+	return strings.TrimSuffix(name, filepath.Ext(name)) + ".png"
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+func runFilters(args []string, stdout, stderr io.Writer) int {
+	for _, f := range filters.All() {
+		fmt.Fprintf(stdout, "%-12s %s (%s)\n", f.Name(), f.Label(), f.Category())
+		for _, p := range f.Params() {
+			fmt.Fprintf(stdout, "    %-10s %s\n", p.Name, describeParam(p))
+		}
+	}
+	return 0
+}
+
+func describeParam(p filters.Param) string {
+	switch p.Kind {
+	case filters.ParamInt:
+		return fmt.Sprintf("int %v..%v, default %v", p.Min, p.Max, p.Default)
+	case filters.ParamFloat:
+		return fmt.Sprintf("float %v..%v, default %v", p.Min, p.Max, p.Default)
+	case filters.ParamBool:
+		return fmt.Sprintf("bool, default %v", p.Default)
+	case filters.ParamChoice:
+		return fmt.Sprintf("one of %s, default %v", strings.Join(p.Choices, "|"), p.Default)
+	case filters.ParamPoints:
+		return "list of points, set it in a preset"
+	case filters.ParamColor:
+		return fmt.Sprintf("color #rrggbb[aa], default %s", filters.FormatColor(p.Default.(color.RGBA)))
+	case filters.ParamKernel:
+		return "kernel matrix or {values, anchor, divisor, offset}, from @file or a preset"
+	}
+	return ""
+}
@@ -2,178 +2,116 @@ package filters
 
 import (
 	"image"
-	"image-filter-editor/internal/utils"
-	"image/color"
 	"math"
 	"sort"
 )
 
 const (
-    BRIGHTNESS_FACTOR = 30    
-    CONTRAST_FACTOR   = 1.5   
-    GAMMA_FACTOR      = 1.8   
+	BRIGHTNESS_FACTOR = 30
+	CONTRAST_FACTOR   = 1.5
+	GAMMA_FACTOR      = 1.8
 )
 
 type Point struct {
-	X, Y float64
+	X float64 `json:"x" yaml:"x"`
+	Y float64 `json:"y" yaml:"y"`
 }
 
-func InvertImage(src *image.RGBA) *image.RGBA {
-	bounds := src.Bounds()
-	result := image.NewRGBA(bounds)
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			origColor := src.At(x, y)
-			r, g, b, a := origColor.RGBA()
-			r8 := uint8(r >> 8)
-			g8 := uint8(g >> 8)
-			b8 := uint8(b >> 8)
-			a8 := uint8(a >> 8)
-
-			
-			invColor := color.RGBA{
-				R: 255 - r8,
-				G: 255 - g8,
-				B: 255 - b8,
-				A: a8,
-			}
-			result.Set(x, y, invColor)
-		}
-	}
-	return result
+func init() {
+	Register(100, NewPointFilter("invert", "Invert", "Adjust", nil,
+		func(p Params) Curve {
+			return invertCurve
+		}))
+	Register(110, NewPointFilter("brightness", "Brightness", "Adjust",
+		[]Param{{Name: "amount", Label: "Brightness", Kind: ParamInt, Min: -100, Max: 100, Step: 1, Default: BRIGHTNESS_FACTOR}},
+		func(p Params) Curve {
+			return brightnessCurve(p.Int("amount"))
+		}))
+	Register(120, NewPointFilter("contrast", "Contrast", "Adjust",
+		[]Param{{Name: "factor", Label: "Contrast", Kind: ParamFloat, Min: 0, Max: 3, Step: 0.1, Default: CONTRAST_FACTOR}},
+		func(p Params) Curve {
+			return contrastCurve(p.Float("factor"))
+		}))
+	Register(130, NewPointFilter("gamma", "Gamma", "Adjust",
+		[]Param{{Name: "gamma", Label: "Gamma", Kind: ParamFloat, Min: 0.1, Max: 3, Step: 0.1, Default: GAMMA_FACTOR}},
+		func(p Params) Curve {
+			return gammaCurve(p.Float("gamma"))
+		}))
+	Register(140, NewPointFilter("curve", "Functional Filter", "Adjust",
+		[]Param{{Name: "points", Label: "Control Points", Kind: ParamPoints, Default: []Point{{0, 0}, {255, 255}}}},
+		func(p Params) Curve {
+			return functionalCurve(p.Points("points"))
+		}))
 }
 
+func InvertImage(src *image.RGBA) *image.RGBA {
+	return ApplyCurve(src, invertCurve)
+}
 
 func BrightnessCorrection(src *image.RGBA, factor int) *image.RGBA {
-	bounds := src.Bounds()
-	result := image.NewRGBA(bounds)
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			originalColor := src.At(x, y)
-			r, g, b, a := originalColor.RGBA()
-			
-			
-			r8 := int(r>>8) + factor
-			g8 := int(g>>8) + factor
-			b8 := int(b>>8) + factor
-			
-			
-			r8 = utils.Clamp(r8, 0, 255)
-			g8 = utils.Clamp(g8, 0, 255)
-			b8 = utils.Clamp(b8, 0, 255)
-			
-			result.Set(x, y, color.RGBA{uint8(r8), uint8(g8), uint8(b8), uint8(a>>8)})
-		}
-	}
-	return result
+	return ApplyCurve(src, brightnessCurve(factor))
 }
 
-
 func ContrastEnhancement(src *image.RGBA, factor float64) *image.RGBA {
-	bounds := src.Bounds()
-	result := image.NewRGBA(bounds)
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			originalColor := src.At(x, y)
-			r, g, b, a := originalColor.RGBA()
-			
-			r8 := float64(r>>8)
-			g8 := float64(g>>8)
-			b8 := float64(b>>8)
-			
-			
-			r8 = ((r8 - 128) * factor) + 128
-			g8 = ((g8 - 128) * factor) + 128
-			b8 = ((b8 - 128) * factor) + 128
-			
-			
-			result.Set(x, y, color.RGBA{
-				uint8(utils.Clamp(int(r8), 0, 255)),
-				uint8(utils.Clamp(int(g8), 0, 255)),
-				uint8(utils.Clamp(int(b8), 0, 255)),
-				uint8(a>>8),
-			})
-		}
-	}
-	return result
+	return ApplyCurve(src, contrastCurve(factor))
 }
 
-
 func GammaCorrection(src *image.RGBA, gamma float64) *image.RGBA {
-	bounds := src.Bounds()
-	result := image.NewRGBA(bounds)
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			originalColor := src.At(x, y)
-			r, g, b, a := originalColor.RGBA()
-			
-			
-			r32 := float64(r>>8) / 255.0
-			g32 := float64(g>>8) / 255.0
-			b32 := float64(b>>8) / 255.0
-			
-			
-			r32 = math.Pow(r32, gamma)
-			g32 = math.Pow(g32, gamma)
-			b32 = math.Pow(b32, gamma)
-			
-			
-			result.Set(x, y, color.RGBA{
-				uint8(utils.Clamp(int(r32*255), 0, 255)),
-				uint8(utils.Clamp(int(g32*255), 0, 255)),
-				uint8(utils.Clamp(int(b32*255), 0, 255)),
-				uint8(a>>8),
-			})
-		}
-	}
-	return result
+	return ApplyCurve(src, gammaCurve(gamma))
 }
 
-
 func ApplyFunctionalFilter(src *image.RGBA, points []Point) *image.RGBA {
-	bounds := src.Bounds()
-	result := image.NewRGBA(bounds)
+	return ApplyCurve(src, functionalCurve(points))
+}
 
+func invertCurve(x float64) float64 {
+	return 255 - x
+}
 
-	sort.Slice(points, func(i, j int) bool {
-			return points[i].X < points[j].X
-	})
+func brightnessCurve(factor int) Curve {
+	return func(x float64) float64 {
+		return x + float64(factor)
+	}
+}
 
+func contrastCurve(factor float64) Curve {
+	return func(x float64) float64 {
+		return (x-128)*factor + 128
+	}
+}
 
-	var lookup [256]uint8
-	for i := 0; i < 256; i++ {
-			x := float64(i)
-		
-			var p1, p2 Point
-			for j := 0; j < len(points)-1; j++ {
-					if x >= points[j].X && x <= points[j+1].X {
-							p1, p2 = points[j], points[j+1]
-							break
-					}
-			}
-			
-		
-			t := (x - p1.X) / (p2.X - p1.X)
-			y := p1.Y + t*(p2.Y-p1.Y)
-			
-		
-			lookup[i] = uint8(utils.Clamp(int(y), 0, 255))
+// gammaCurve clamps its input first, a negative base has no real power.
+func gammaCurve(gamma float64) Curve {
+	return func(x float64) float64 {
+		x = math.Max(0, math.Min(255, x))
+		return math.Pow(x/255.0, gamma) * 255
 	}
+}
 
+// functionalCurve interpolates linearly between the control points and
+// holds the first and last value outside of them.
+func functionalCurve(points []Point) Curve {
+	points = append([]Point(nil), points...)
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].X < points[j].X
+	})
 
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-			for x := bounds.Min.X; x < bounds.Max.X; x++ {
-					originalColor := src.At(x, y)
-					r, g, b, a := originalColor.RGBA()
-					
-					result.Set(x, y, color.RGBA{
-							R: lookup[r>>8],
-							G: lookup[g>>8],
-							B: lookup[b>>8],
-							A: uint8(a>>8),
-					})
+	return func(x float64) float64 {
+		p1, p2 := points[0], points[len(points)-1]
+		if x <= p1.X {
+			return p1.Y
+		}
+		if x >= p2.X {
+			return p2.Y
+		}
+		for j := 0; j < len(points)-1; j++ {
+			if x >= points[j].X && x <= points[j+1].X {
+				p1, p2 = points[j], points[j+1]
+				break
 			}
-	}
+		}
 
-	return result
-}
\ No newline at end of file
+		t := (x - p1.X) / (p2.X - p1.X)
+		return p1.Y + t*(p2.Y-p1.Y)
+	}
+}
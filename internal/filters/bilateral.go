@@ -0,0 +1,133 @@
+package filters
+
+import (
+	"image"
+	"math"
+)
+
+const (
+	BILATERAL_SPATIAL_SIGMA = 3.0
+	BILATERAL_RANGE_SIGMA   = 25.0
+)
+
+func init() {
+	Register(245, NewFilter("bilateral", "Bilateral", "Convolution",
+		append([]Param{
+			{Name: "spatial", Label: "Spatial Sigma", Kind: ParamFloat, Min: 0.5, Max: 20, Step: 0.5, Default: BILATERAL_SPATIAL_SIGMA},
+			{Name: "range", Label: "Range Sigma", Kind: ParamFloat, Min: 1, Max: 128, Step: 1, Default: BILATERAL_RANGE_SIGMA},
+			{Name: "luminance", Label: "Compare luminance only", Kind: ParamBool, Default: false},
+		}, borderParams()...),
+		func(src *image.RGBA, p Params) *image.RGBA {
+			return BilateralFilter(src, p.Float("spatial"), p.Float("range"), p.Bool("luminance"), borderFromParams(p))
+		}))
+}
+
+// BilateralFilter smooths like a Gaussian of spatialSigma, but every
+// neighbour is also weighted by how close its value is to the centre
+// pixel (rangeSigma), so edges with a large step survive. With luminance
+// set, the similarity is measured on the luminance and the same weight
+// is used for all channels, which avoids colour fringes. The window
+// reaches 2*spatialSigma, beyond that the weights hardly matter.
+func BilateralFilter(src *image.RGBA, spatialSigma, rangeSigma float64, luminance bool, border Border) *image.RGBA {
+	radius := max(1, int(math.Ceil(2*spatialSigma)))
+	size := 2*radius + 1
+
+	spatial := make([]float64, size*size)
+	for dy := -radius; dy <= radius; dy++ {
+		for dx := -radius; dx <= radius; dx++ {
+			spatial[(dy+radius)*size+dx+radius] = math.Exp(-float64(dx*dx+dy*dy) / (2 * spatialSigma * spatialSigma))
+		}
+	}
+	var similar [256]float64
+	for d := range similar {
+		similar[d] = math.Exp(-float64(d*d) / (2 * rangeSigma * rangeSigma))
+	}
+
+	in, result, area := border.prepare(src, margins{radius, radius, radius, radius})
+
+	var luma []uint8
+	if luminance {
+		luma = lumaPlane(in)
+	}
+	ib := in.Bounds()
+	lumaAt := func(x, y int) int {
+		return int(luma[(y-ib.Min.Y)*ib.Dx()+x-ib.Min.X])
+	}
+
+	parallelRows(area, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			d := result.PixOffset(area.Min.X, y)
+			for x := area.Min.X; x < area.Max.X; x++ {
+				c := in.PixOffset(x, y)
+				cr, cg, cb := int(in.Pix[c]), int(in.Pix[c+1]), int(in.Pix[c+2])
+				var cl int
+				if luminance {
+					cl = lumaAt(x, y)
+				}
+
+				var r, g, b, wr, wg, wb float64
+				k := 0
+				for dy := -radius; dy <= radius; dy++ {
+					i := in.PixOffset(x-radius, y+dy)
+					for dx := -radius; dx <= radius; dx++ {
+						pr, pg, pb := int(in.Pix[i]), int(in.Pix[i+1]), int(in.Pix[i+2])
+						s := spatial[k]
+						if luminance {
+							w := s * similar[abs(lumaAt(x+dx, y+dy)-cl)]
+							r += float64(pr) * w
+							g += float64(pg) * w
+							b += float64(pb) * w
+							wr += w
+						} else {
+							w := s * similar[abs(pr-cr)]
+							r += float64(pr) * w
+							wr += w
+							w = s * similar[abs(pg-cg)]
+							g += float64(pg) * w
+							wg += w
+							w = s * similar[abs(pb-cb)]
+							b += float64(pb) * w
+							wb += w
+						}
+						i += 4
+						k++
+					}
+				}
+				if luminance {
+					wg, wb = wr, wr
+				}
+
+				result.Pix[d] = roundChannel(r / wr)
+				result.Pix[d+1] = roundChannel(g / wg)
+				result.Pix[d+2] = roundChannel(b / wb)
+				result.Pix[d+3] = in.Pix[c+3]
+				d += 4
+			}
+		}
+	})
+	return result
+}
+
+// lumaPlane returns the Rec. 601 luminance of every pixel of img, row by row.
+func lumaPlane(img *image.RGBA) []uint8 {
+	b := img.Bounds()
+	luma := make([]uint8, b.Dx()*b.Dy())
+	parallelRows(b, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			s := img.Pix[img.PixOffset(b.Min.X, y):][:b.Dx()*4]
+			l := luma[(y-b.Min.Y)*b.Dx():][:b.Dx()]
+			for x := range l {
+				i := x * 4
+				l[x] = uint8((299*int(s[i]) + 587*int(s[i+1]) + 114*int(s[i+2]) + 500) / 1000)
+			}
+		}
+	})
+	return luma
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
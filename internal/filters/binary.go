@@ -0,0 +1,496 @@
+package filters
+
+import (
+	"image"
+	"math"
+	"sort"
+)
+
+// The binary filters treat pixels with a luminance of 128 or more as
+// foreground and output white foreground on black, keeping alpha.
+
+// ThinningMethod selects the thinning algorithm.
+type ThinningMethod int
+
+const (
+	ZhangSuen ThinningMethod = iota
+	GuoHall
+)
+
+var thinningMethodNames = []string{"zhang-suen", "guo-hall"}
+
+func (m ThinningMethod) String() string {
+	return enumName(thinningMethodNames, int(m), "ThinningMethod")
+}
+
+func ParseThinningMethod(s string) (ThinningMethod, error) {
+	i, err := parseName(thinningMethodNames, s, "thinning method")
+	return ThinningMethod(i), err
+}
+
+func init() {
+	Register(535, NewFilter("hitmiss", "Hit-or-Miss", "Morphology",
+		append([]Param{
+			{Name: "template", Label: "Template (1 = foreground, -1 = background, 0 = any)", Kind: ParamKernel,
+				Default: NewKernel([][]float64{{0, -1, -1}, {1, 1, -1}, {0, 1, 0}})},
+			{Name: "rotations", Label: "Match all 4 rotations", Kind: ParamBool, Default: true},
+		}, borderParams()...),
+		func(src *image.RGBA, p Params) *image.RGBA {
+			return HitOrMiss(src, p.Kernel("template"), p.Bool("rotations"), borderFromParams(p))
+		}))
+	Register(540, NewFilter("thin", "Thinning", "Morphology",
+		[]Param{{Name: "method", Label: "Method", Kind: ParamChoice, Default: ZhangSuen.String(), Choices: thinningMethodNames}},
+		func(src *image.RGBA, p Params) *image.RGBA {
+			method, _ := ParseThinningMethod(p.String("method"))
+			return Thin(src, method)
+		}))
+	Register(545, NewFilter("prune", "Prune Spurs", "Morphology",
+		[]Param{{Name: "length", Label: "Spur Length", Kind: ParamInt, Min: 1, Max: 100, Step: 1, Default: 10}},
+		func(src *image.RGBA, p Params) *image.RGBA {
+			return Prune(src, p.Int("length"))
+		}))
+	Register(550, NewFilter("convexhull", "Convex Hull", "Morphology",
+		[]Param{{Name: "objects", Label: "One hull per object", Kind: ParamBool, Default: true}},
+		func(src *image.RGBA, p Params) *image.RGBA {
+			return ConvexHull(src, p.Bool("objects"))
+		}))
+}
+
+// bitmap is the foreground of rect, padded with one pixel of background
+// on every side so that the 8 neighbours of a pixel can be read without
+// bounds checks.
+type bitmap struct {
+	rect image.Rectangle
+	w    int
+	bits []bool
+}
+
+func newBitmap(src *image.RGBA, rect image.Rectangle) *bitmap {
+	b := &bitmap{rect: rect, w: rect.Dx() + 2, bits: make([]bool, (rect.Dx()+2)*(rect.Dy()+2))}
+	parallelRows(rect, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			s := src.Pix[src.PixOffset(rect.Min.X, y):][:rect.Dx()*4]
+			row := b.bits[b.index(rect.Min.X, y):][:rect.Dx()]
+			for x := range row {
+				row[x] = 299*int(s[x*4])+587*int(s[x*4+1])+114*int(s[x*4+2]) >= 127500
+			}
+		}
+	})
+	return b
+}
+
+func (b *bitmap) index(x, y int) int {
+	return (y-b.rect.Min.Y+1)*b.w + x - b.rect.Min.X + 1
+}
+
+// neighbours returns the offsets of the 8 neighbours clockwise from north,
+// P2 to P9 in the usual thinning notation.
+func (b *bitmap) neighbours() [8]int {
+	w := b.w
+	return [8]int{-w, -w + 1, 1, w + 1, w, w - 1, -1, -w - 1}
+}
+
+// foreground returns the indices of all foreground pixels.
+func (b *bitmap) foreground() []int {
+	var fg []int
+	for i, v := range b.bits {
+		if v {
+			fg = append(fg, i)
+		}
+	}
+	return fg
+}
+
+// render draws the bitmap over rect, with alpha from src.
+func (b *bitmap) render(src *image.RGBA) *image.RGBA {
+	result := image.NewRGBA(b.rect)
+	parallelRows(b.rect, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			d := result.Pix[result.PixOffset(b.rect.Min.X, y):][:b.rect.Dx()*4]
+			row := b.bits[b.index(b.rect.Min.X, y):][:b.rect.Dx()]
+			for x, v := range row {
+				setBinary(d[x*4:x*4+4], v, src.Pix[src.PixOffset(b.rect.Min.X+x, y)+3])
+			}
+		}
+	})
+	return result
+}
+
+// HitOrMiss marks the pixels where template matches: cells of 1 must be
+// foreground, cells of -1 (any negative value) background and cells of 0
+// are ignored. The template anchor is placed on the pixel. With rotations,
+// the template turned by 90, 180 and 270 degrees is tried as well.
+func HitOrMiss(src *image.RGBA, template Kernel, rotations bool, border Border) *image.RGBA {
+	if template.Validate() != nil {
+		template = NewKernel([][]float64{{1}})
+	}
+	templates := []Kernel{template}
+	if rotations {
+		for i := 0; i < 3; i++ {
+			templates = append(templates, rotateKernel(templates[i]))
+		}
+	}
+	var m margins
+	for _, t := range templates {
+		m.left = max(m.left, t.Anchor.X)
+		m.top = max(m.top, t.Anchor.Y)
+		m.right = max(m.right, t.Width()-1-t.Anchor.X)
+		m.bottom = max(m.bottom, t.Height()-1-t.Anchor.Y)
+	}
+
+	in, dst, area := border.prepare(src, m)
+	bits := newBitmap(in, in.Bounds())
+	type cell struct {
+		offset     int
+		foreground bool
+	}
+	cells := make([][]cell, len(templates))
+	for i, t := range templates {
+		for y, row := range t.Values {
+			for x, v := range row {
+				if v != 0 {
+					cells[i] = append(cells[i], cell{(y-t.Anchor.Y)*bits.w + x - t.Anchor.X, v > 0})
+				}
+			}
+		}
+	}
+
+	parallelRows(area, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			d := dst.PixOffset(area.Min.X, y)
+			for x := area.Min.X; x < area.Max.X; x++ {
+				i := bits.index(x, y)
+				hit := false
+				for _, cs := range cells {
+					hit = true
+					for _, c := range cs {
+						if bits.bits[i+c.offset] != c.foreground {
+							hit = false
+							break
+						}
+					}
+					if hit {
+						break
+					}
+				}
+				setBinary(dst.Pix[d:d+4], hit, in.Pix[in.PixOffset(x, y)+3])
+				d += 4
+			}
+		}
+	})
+	return dst
+}
+
+// rotateKernel turns k by 90 degrees clockwise, anchor included.
+func rotateKernel(k Kernel) Kernel {
+	w, h := k.Width(), k.Height()
+	r := k.Clone()
+	r.Values = make([][]float64, w)
+	for y := range r.Values {
+		r.Values[y] = make([]float64, h)
+		for x := range r.Values[y] {
+			r.Values[y][x] = k.Values[h-1-x][y]
+		}
+	}
+	r.Anchor = image.Pt(h-1-k.Anchor.Y, k.Anchor.X)
+	return r
+}
+
+// Thin reduces the foreground to 8-connected lines one pixel wide that
+// keep the topology of the shapes.
+func Thin(src *image.RGBA, method ThinningMethod) *image.RGBA {
+	bits := newBitmap(src, src.Bounds())
+	bits.thin(method)
+	return bits.render(src)
+}
+
+func (b *bitmap) thin(method ThinningMethod) {
+	nb := b.neighbours()
+	// Only pixels next to the background can be removed, and that only
+	// changes around removed pixels. stable counts the passes a candidate
+	// survived since its neighbourhood last changed, after one pass of
+	// each kind it is dropped until a neighbour is removed.
+	stable := make([]int8, len(b.bits))
+	inList := make([]bool, len(b.bits))
+	var cand []int
+	for _, i := range b.foreground() {
+		for _, o := range nb {
+			if !b.bits[i+o] {
+				cand = append(cand, i)
+				inList[i] = true
+				break
+			}
+		}
+	}
+
+	var remove []int
+	for pass := 0; len(cand) > 0; pass = 1 - pass {
+		remove = remove[:0]
+		for _, i := range cand {
+			var p [8]bool
+			for k, o := range nb {
+				p[k] = b.bits[i+o]
+			}
+			if thinningDeletes(method, pass, p) {
+				remove = append(remove, i)
+			}
+		}
+		for _, i := range remove {
+			b.bits[i] = false
+		}
+
+		n := 0
+		for _, i := range cand {
+			stable[i]++
+			if b.bits[i] && stable[i] < 2 {
+				cand[n] = i
+				n++
+			} else {
+				inList[i] = false
+			}
+		}
+		cand = cand[:n]
+		for _, i := range remove {
+			for _, o := range nb {
+				if j := i + o; b.bits[j] {
+					stable[j] = 0
+					if !inList[j] {
+						inList[j] = true
+						cand = append(cand, j)
+					}
+				}
+			}
+		}
+	}
+}
+
+// thinningDeletes decides whether a foreground pixel with the neighbours
+// p (P2 to P9) is removed in the given sub-iteration.
+func thinningDeletes(method ThinningMethod, pass int, p [8]bool) bool {
+	p2, p3, p4, p5, p6, p7, p8, p9 := p[0], p[1], p[2], p[3], p[4], p[5], p[6], p[7]
+	if method == GuoHall {
+		c := b2i(!p2 && (p3 || p4)) + b2i(!p4 && (p5 || p6)) + b2i(!p6 && (p7 || p8)) + b2i(!p8 && (p9 || p2))
+		n1 := b2i(p9 || p2) + b2i(p3 || p4) + b2i(p5 || p6) + b2i(p7 || p8)
+		n2 := b2i(p2 || p3) + b2i(p4 || p5) + b2i(p6 || p7) + b2i(p8 || p9)
+		n := min(n1, n2)
+		var m bool
+		if pass == 0 {
+			m = (p6 || p7 || !p9) && p8
+		} else {
+			m = (p2 || p3 || !p5) && p4
+		}
+		return c == 1 && n >= 2 && n <= 3 && !m
+	}
+
+	count, transitions := ringCounts(p)
+	if count < 2 || count > 6 || transitions != 1 {
+		return false
+	}
+	if pass == 0 {
+		return !(p2 && p4 && p6) && !(p4 && p6 && p8)
+	}
+	return !(p2 && p4 && p8) && !(p2 && p6 && p8)
+}
+
+// ringCounts returns the number of foreground neighbours and the number
+// of background to foreground transitions going once around them.
+func ringCounts(p [8]bool) (count, transitions int) {
+	for k, v := range p {
+		if v {
+			count++
+			if !p[(k+7)%8] {
+				transitions++
+			}
+		}
+	}
+	return count, transitions
+}
+
+func b2i(v bool) int {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+// Prune removes spurs of up to length pixels from a skeleton, such as the
+// output of Thin. Endpoints are stripped length times, then the remaining
+// branches are grown back along the removed pixels by the same amount so
+// that they keep their length. Closed loops are left alone.
+func Prune(src *image.RGBA, length int) *image.RGBA {
+	bits := newBitmap(src, src.Bounds())
+	bits.prune(length)
+	return bits.render(src)
+}
+
+func (b *bitmap) prune(length int) {
+	nb := b.neighbours()
+	removed := make([]bool, len(b.bits))
+	inList := make([]bool, len(b.bits))
+	endpoints := func(idx []int) []int {
+		var ends []int
+		for _, i := range idx {
+			var p [8]bool
+			for k, o := range nb {
+				p[k] = b.bits[i+o]
+			}
+			if count, transitions := ringCounts(p); count <= 2 && transitions == 1 {
+				ends = append(ends, i)
+			}
+		}
+		return ends
+	}
+
+	// New endpoints can only appear next to removed ones.
+	ends := endpoints(b.foreground())
+	for n := 0; n < length && len(ends) > 0; n++ {
+		for _, i := range ends {
+			b.bits[i] = false
+			removed[i] = true
+		}
+		var next []int
+		for _, i := range ends {
+			for _, o := range nb {
+				if j := i + o; b.bits[j] && !inList[j] {
+					inList[j] = true
+					next = append(next, j)
+				}
+			}
+		}
+		for _, j := range next {
+			inList[j] = false
+		}
+		ends = endpoints(next)
+	}
+
+	frontier := endpoints(b.foreground())
+	for n := 0; n < length && len(frontier) > 0; n++ {
+		var next []int
+		for _, i := range frontier {
+			for _, o := range nb {
+				if j := i + o; removed[j] {
+					removed[j] = false
+					b.bits[j] = true
+					next = append(next, j)
+				}
+			}
+		}
+		frontier = next
+	}
+}
+
+// ConvexHull fills the convex hull of every 8-connected object, or of all
+// the foreground together when objects is false.
+func ConvexHull(src *image.RGBA, objects bool) *image.RGBA {
+	bits := newBitmap(src, src.Bounds())
+	var groups [][]int
+	if objects {
+		groups = bits.components()
+	} else if fg := bits.foreground(); len(fg) > 0 {
+		groups = [][]int{fg}
+	}
+	for _, g := range groups {
+		bits.fillHull(g)
+	}
+	return bits.render(src)
+}
+
+// components returns the pixel indices of every 8-connected object.
+func (b *bitmap) components() [][]int {
+	nb := b.neighbours()
+	seen := make([]bool, len(b.bits))
+	var groups [][]int
+	for _, start := range b.foreground() {
+		if seen[start] {
+			continue
+		}
+		seen[start] = true
+		group := []int{start}
+		for k := 0; k < len(group); k++ {
+			for _, o := range nb {
+				if j := group[k] + o; b.bits[j] && !seen[j] {
+					seen[j] = true
+					group = append(group, j)
+				}
+			}
+		}
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// fillHull sets every pixel whose centre lies in the convex hull of the
+// centres of the pixels idx.
+func (b *bitmap) fillHull(idx []int) {
+	// Only the outermost pixels of every row can be hull vertices.
+	rows := map[int][2]int{}
+	for _, i := range idx {
+		x, y := i%b.w, i/b.w
+		if r, ok := rows[y]; !ok {
+			rows[y] = [2]int{x, x}
+		} else {
+			rows[y] = [2]int{min(r[0], x), max(r[1], x)}
+		}
+	}
+	var pts []image.Point
+	for y, r := range rows {
+		pts = append(pts, image.Pt(r[0], y), image.Pt(r[1], y))
+	}
+	hull := convexHull(pts)
+
+	minY, maxY := hull[0].Y, hull[0].Y
+	for _, p := range hull {
+		minY, maxY = min(minY, p.Y), max(maxY, p.Y)
+	}
+	for y := minY; y <= maxY; y++ {
+		lo, hi := math.Inf(1), math.Inf(-1)
+		for k, a := range hull {
+			c := hull[(k+1)%len(hull)]
+			if (a.Y < y && c.Y < y) || (a.Y > y && c.Y > y) {
+				continue
+			}
+			if a.Y == c.Y {
+				lo = math.Min(lo, float64(min(a.X, c.X)))
+				hi = math.Max(hi, float64(max(a.X, c.X)))
+				continue
+			}
+			x := float64(a.X) + float64((y-a.Y)*(c.X-a.X))/float64(c.Y-a.Y)
+			lo, hi = math.Min(lo, x), math.Max(hi, x)
+		}
+		for x := int(math.Ceil(lo - 1e-9)); x <= int(math.Floor(hi+1e-9)); x++ {
+			b.bits[y*b.w+x] = true
+		}
+	}
+}
+
+// convexHull returns the vertices of the convex hull of pts in order,
+// using Andrew's monotone chain. Collinear points are dropped.
+func convexHull(pts []image.Point) []image.Point {
+	sort.Slice(pts, func(i, j int) bool {
+		if pts[i].X != pts[j].X {
+			return pts[i].X < pts[j].X
+		}
+		return pts[i].Y < pts[j].Y
+	})
+	cross := func(o, a, b image.Point) int {
+		return (a.X-o.X)*(b.Y-o.Y) - (a.Y-o.Y)*(b.X-o.X)
+	}
+	hull := make([]image.Point, 0, 2*len(pts))
+	for _, p := range pts {
+		for len(hull) >= 2 && cross(hull[len(hull)-2], hull[len(hull)-1], p) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, p)
+	}
+	lower := len(hull) + 1
+	for i := len(pts) - 2; i >= 0; i-- {
+		for len(hull) >= lower && cross(hull[len(hull)-2], hull[len(hull)-1], pts[i]) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, pts[i])
+	}
+	if len(hull) > 1 {
+		hull = hull[:len(hull)-1]
+	}
+	return hull
+}
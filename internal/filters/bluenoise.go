@@ -0,0 +1,248 @@
+package filters
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// BLUE_NOISE_SIGMA is the width of the Gaussian that measures how
+	// clustered the pixels of a void-and-cluster pattern are.
+	BLUE_NOISE_SIGMA = 1.5
+	BLUE_NOISE_SEED  = 1
+)
+
+// blueNoiseSizes are the mask sizes BlueNoiseMap offers, as the choices
+// of the dither filter's noise parameter. Each one is generated at most
+// once per run.
+var blueNoiseSizes = []string{"16", "32", "64", "128"}
+
+type blueNoiseMask struct {
+	once sync.Once
+	m    ThresholdMap
+}
+
+var blueNoise = struct {
+	sync.Mutex
+	masks map[int]*blueNoiseMask
+}{masks: map[int]*blueNoiseMask{}}
+
+// BlueNoiseMap returns the blue noise mask of size x size, which must be
+// one of 16, 32, 64 and 128. Masks are generated once and then kept in
+// memory and in the user cache directory. Only callers asking for the
+// same size wait for each other.
+func BlueNoiseMap(size int) (ThresholdMap, error) {
+	if !slices.Contains(blueNoiseSizes, strconv.Itoa(size)) {
+		return nil, fmt.Errorf("no blue noise mask of size %d, use one of %s", size, strings.Join(blueNoiseSizes, ", "))
+	}
+	blueNoise.Lock()
+	mask, ok := blueNoise.masks[size]
+	if !ok {
+		mask = &blueNoiseMask{}
+		blueNoise.masks[size] = mask
+	}
+	blueNoise.Unlock()
+	mask.once.Do(func() { mask.m = loadBlueNoise(size) })
+	return mask.m, nil
+}
+
+// loadBlueNoise reads the mask from the cache, or generates and caches it.
+func loadBlueNoise(size int) ThresholdMap {
+	path, err := blueNoiseCachePath(size)
+	if err == nil {
+		if m, err := ReadThresholdMap(path); err == nil && len(m) == size && len(m[0]) == size {
+			return m
+		}
+	}
+	m := GenerateBlueNoise(size, BLUE_NOISE_SEED)
+	if path != "" {
+		// The cache is only an optimization, failing to write it just
+		// means generating the mask again next time.
+		_ = writeThresholdMap(path, m)
+	}
+	return m
+}
+
+func blueNoiseCachePath(size int) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "imagefilter", fmt.Sprintf("bluenoise-%d.txt", size)), nil
+}
+
+// writeThresholdMap saves m in the text format ReadThresholdMap reads.
+func writeThresholdMap(path string, m ThresholdMap) error {
+	var b strings.Builder
+	for _, row := range m {
+		for x, v := range row {
+			if x > 0 {
+				b.WriteByte(' ')
+			}
+			b.WriteString(strconv.Itoa(v))
+		}
+		b.WriteByte('\n')
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(b.String()), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// GenerateBlueNoise builds a size x size threshold map with Ulichney's
+// void-and-cluster method. Distances wrap around the edges, so the mask
+// tiles without seams. The same seed always gives the same mask.
+func GenerateBlueNoise(size int, seed uint64) ThresholdMap {
+	size = max(2, size)
+	n := size * size
+	rng := rand.New(rand.NewPCG(seed, uint64(size)))
+
+	// The energy of a pixel is the Gaussian weighted number of set pixels
+	// around it, the Gaussian being cut off at 4 sigma or half the tile.
+	r := min((size-1)/2, int(math.Ceil(4*BLUE_NOISE_SIGMA)))
+	type tap struct {
+		dx, dy int
+		w      float64
+	}
+	var taps []tap
+	for dy := -r; dy <= r; dy++ {
+		for dx := -r; dx <= r; dx++ {
+			taps = append(taps, tap{dx, dy, math.Exp(-float64(dx*dx+dy*dy) / (2 * BLUE_NOISE_SIGMA * BLUE_NOISE_SIGMA))})
+		}
+	}
+
+	set := func(p *pattern, i int, on bool) {
+		heap.Remove(p.heaps[b2i(p.bits[i])], p.pos[i])
+		p.bits[i] = on
+		heap.Push(p.heaps[b2i(on)], i)
+		sign := 1.0
+		if !on {
+			sign = -1
+		}
+		x, y := i%size, i/size
+		for _, t := range taps {
+			q := (y+t.dy+size)%size*size + (x+t.dx+size)%size
+			p.energy[q] += sign * t.w
+			heap.Fix(p.heaps[b2i(p.bits[q])], p.pos[q])
+		}
+	}
+	// tightest returns the set pixel with the most energy, largestVoid the
+	// unset one with the least.
+	tightest := func(p *pattern) int { return p.heaps[1].pixels[0] }
+	largestVoid := func(p *pattern) int { return p.heaps[0].pixels[0] }
+
+	// A random initial pattern of about a tenth of the pixels, relaxed by
+	// moving the pixel of the tightest cluster into the largest void until
+	// that no longer changes anything.
+	ones := max(1, n/10)
+	initial := newPattern(make([]bool, n), make([]float64, n))
+	for _, i := range rng.Perm(n)[:ones] {
+		set(initial, i, true)
+	}
+	for iter := 0; iter < 10*n; iter++ {
+		c := tightest(initial)
+		set(initial, c, false)
+		v := largestVoid(initial)
+		set(initial, v, true)
+		if v == c {
+			break
+		}
+	}
+
+	ranks := make([]int, n)
+	// Ranks below the initial pattern: remove its tightest clusters one
+	// by one.
+	p := newPattern(append([]bool(nil), initial.bits...), append([]float64(nil), initial.energy...))
+	for rank := ones - 1; rank >= 0; rank-- {
+		c := tightest(p)
+		set(p, c, false)
+		ranks[c] = rank
+	}
+	// Ranks above it: fill the largest voids one by one.
+	for rank := ones; rank < n; rank++ {
+		v := largestVoid(initial)
+		set(initial, v, true)
+		ranks[v] = rank
+	}
+
+	m := make(ThresholdMap, size)
+	for y := range m {
+		m[y] = ranks[y*size : (y+1)*size]
+	}
+	return m
+}
+
+// pattern is a binary pattern with the energy of its pixels, ordered in
+// two heaps so that the tightest cluster and the largest void are found
+// without scanning the whole tile.
+type pattern struct {
+	bits   []bool
+	energy []float64
+	// heaps[0] holds the unset pixels, least energy first, heaps[1] the
+	// set ones, most energy first. pos is the position of every pixel in
+	// its heap.
+	heaps [2]*energyHeap
+	pos   []int
+}
+
+func newPattern(bits []bool, energy []float64) *pattern {
+	p := &pattern{bits: bits, energy: energy, pos: make([]int, len(bits))}
+	p.heaps[0] = &energyHeap{p: p, sign: 1}
+	p.heaps[1] = &energyHeap{p: p, sign: -1}
+	for i, on := range bits {
+		h := p.heaps[b2i(on)]
+		p.pos[i] = len(h.pixels)
+		h.pixels = append(h.pixels, i)
+	}
+	heap.Init(p.heaps[0])
+	heap.Init(p.heaps[1])
+	return p
+}
+
+// energyHeap implements heap.Interface over pixels of a pattern. Ties go to
+// the lowest index, so the order does not depend on the heap's history.
+type energyHeap struct {
+	p      *pattern
+	sign   float64
+	pixels []int
+}
+
+func (h *energyHeap) Len() int { return len(h.pixels) }
+
+func (h *energyHeap) Less(a, b int) bool {
+	i, j := h.pixels[a], h.pixels[b]
+	if ei, ej := h.sign*h.p.energy[i], h.sign*h.p.energy[j]; ei != ej {
+		return ei < ej
+	}
+	return i < j
+}
+
+func (h *energyHeap) Swap(a, b int) {
+	h.pixels[a], h.pixels[b] = h.pixels[b], h.pixels[a]
+	h.p.pos[h.pixels[a]] = a
+	h.p.pos[h.pixels[b]] = b
+}
+
+func (h *energyHeap) Push(x any) {
+	i := x.(int)
+	h.p.pos[i] = len(h.pixels)
+	h.pixels = append(h.pixels, i)
+}
+
+func (h *energyHeap) Pop() any {
+	i := h.pixels[len(h.pixels)-1]
+	h.pixels = h.pixels[:len(h.pixels)-1]
+	return i
+}
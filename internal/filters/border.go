@@ -0,0 +1,177 @@
+package filters
+
+import (
+	"image"
+	"image/color"
+)
+
+// BorderMode selects how neighbourhood filters treat pixels whose
+// neighbourhood reaches outside the image.
+type BorderMode int
+
+const (
+	// BorderClamp repeats the outermost pixels.
+	BorderClamp BorderMode = iota
+	// BorderMirror reflects the image at its edges without repeating them.
+	BorderMirror
+	// BorderWrap continues at the opposite edge, as if the image were tiled.
+	BorderWrap
+	// BorderConstant surrounds the image with a single colour.
+	BorderConstant
+	// BorderCrop drops the pixels that cannot be computed, the result is
+	// smaller than the source.
+	BorderCrop
+	// BorderKeep copies those pixels from the source unchanged.
+	BorderKeep
+)
+
+var borderModeNames = []string{"clamp", "mirror", "wrap", "constant", "crop", "keep"}
+
+func (m BorderMode) String() string {
+	return enumName(borderModeNames, int(m), "BorderMode")
+}
+
+func ParseBorderMode(s string) (BorderMode, error) {
+	i, err := parseName(borderModeNames, s, "border mode")
+	return BorderMode(i), err
+}
+
+// Border is the border handling of a neighbourhood filter. Color is only
+// used by BorderConstant.
+type Border struct {
+	Mode  BorderMode
+	Color color.RGBA
+}
+
+// borderParams are appended to the parameters of every neighbourhood
+// filter, borderFromParams reads them back.
+func borderParams() []Param {
+	return []Param{
+		{Name: "border", Label: "Border", Kind: ParamChoice, Default: BorderClamp.String(), Choices: borderModeNames},
+		{Name: "fill", Label: "Fill Color", Kind: ParamColor, Default: color.RGBA{0, 0, 0, 255}},
+	}
+}
+
+func borderFromParams(p Params) Border {
+	mode, _ := ParseBorderMode(p.String("border"))
+	return Border{Mode: mode, Color: p.Color("fill")}
+}
+
+// margins is how far a neighbourhood reaches past its centre pixel in
+// each direction.
+type margins struct {
+	left, top, right, bottom int
+}
+
+// prepare returns the image a neighbourhood filter reads from, the image
+// it writes to and the area of dst it has to compute. For the padding
+// modes src is copied into a larger image so that every pixel of area can
+// be read without bounds checks. dst is already filled outside area.
+func (b Border) prepare(src *image.RGBA, m margins) (in, dst *image.RGBA, area image.Rectangle) {
+	bounds := src.Bounds()
+	inner := image.Rectangle{
+		Min: image.Pt(bounds.Min.X+m.left, bounds.Min.Y+m.top),
+		Max: image.Pt(bounds.Max.X-m.right, bounds.Max.Y-m.bottom),
+	}
+
+	switch b.Mode {
+	case BorderKeep:
+		dst = image.NewRGBA(bounds)
+		copyBorder(src, dst, inner)
+		return src, dst, inner
+	case BorderCrop:
+		if inner.Empty() {
+			inner = image.Rectangle{}
+		}
+		return src, image.NewRGBA(inner), inner
+	}
+	return b.pad(src, m), image.NewRGBA(bounds), bounds
+}
+
+// pad returns a copy of src grown by m, filled according to the mode.
+func (b Border) pad(src *image.RGBA, m margins) *image.RGBA {
+	bounds := src.Bounds()
+	padded := image.NewRGBA(image.Rect(
+		bounds.Min.X-m.left, bounds.Min.Y-m.top,
+		bounds.Max.X+m.right, bounds.Max.Y+m.bottom,
+	))
+	if bounds.Empty() {
+		return padded
+	}
+	pb := padded.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	fill := []uint8{b.Color.R, b.Color.G, b.Color.B, b.Color.A}
+
+	parallelRows(pb, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			d := padded.Pix[padded.PixOffset(pb.Min.X, y):][:pb.Dx()*4]
+			sy := b.index(y-bounds.Min.Y, h)
+			if sy < 0 {
+				for i := 0; i < len(d); i += 4 {
+					copy(d[i:i+4], fill)
+				}
+				continue
+			}
+			s := src.Pix[src.PixOffset(bounds.Min.X, bounds.Min.Y+sy):][:w*4]
+			copy(d[m.left*4:], s)
+			for x := pb.Min.X; x < pb.Max.X; x++ {
+				if x >= bounds.Min.X && x < bounds.Max.X {
+					continue
+				}
+				i := (x - pb.Min.X) * 4
+				if sx := b.index(x-bounds.Min.X, w); sx >= 0 {
+					copy(d[i:i+4], s[sx*4:sx*4+4])
+				} else {
+					copy(d[i:i+4], fill)
+				}
+			}
+		}
+	})
+	return padded
+}
+
+// index maps a coordinate relative to the image origin to a pixel inside
+// an axis of length n, or -1 for the constant colour.
+func (b Border) index(i, n int) int {
+	if i >= 0 && i < n {
+		return i
+	}
+	switch b.Mode {
+	case BorderMirror:
+		if n == 1 {
+			return 0
+		}
+		period := 2 * (n - 1)
+		i = ((i % period) + period) % period
+		if i >= n {
+			i = period - i
+		}
+		return i
+	case BorderWrap:
+		return ((i % n) + n) % n
+	case BorderConstant:
+		return -1
+	}
+	return max(0, min(n-1, i))
+}
+
+// copyBorder copies everything outside inner, which the neighbourhood
+// filters cannot compute, from src to dst unchanged. An empty inner
+// copies the whole image.
+func copyBorder(src, dst *image.RGBA, inner image.Rectangle) {
+	bounds := src.Bounds()
+	rowBytes := bounds.Dx() * 4
+	left := (inner.Min.X - bounds.Min.X) * 4
+	right := (bounds.Max.X - inner.Max.X) * 4
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		s := src.Pix[src.PixOffset(bounds.Min.X, y):][:rowBytes]
+		d := dst.Pix[dst.PixOffset(bounds.Min.X, y):][:rowBytes]
+		if inner.Empty() || y < inner.Min.Y || y >= inner.Max.Y {
+			copy(d, s)
+			continue
+		}
+		copy(d[:left], s[:left])
+		copy(d[rowBytes-right:], s[rowBytes-right:])
+	}
+}
@@ -0,0 +1,134 @@
+package filters
+
+import (
+	"image"
+	"math"
+)
+
+const (
+	CANNY_SIGMA = 1.4
+	CANNY_LOW   = 20.0
+	CANNY_HIGH  = 50.0
+)
+
+func init() {
+	Register(305, NewFilter("canny", "Canny", "Edges",
+		append([]Param{
+			{Name: "sigma", Label: "Sigma", Kind: ParamFloat, Min: 0, Max: 10, Step: 0.1, Default: CANNY_SIGMA},
+			{Name: "low", Label: "Low Threshold", Kind: ParamFloat, Min: 0, Max: 255, Step: 1, Default: CANNY_LOW},
+			{Name: "high", Label: "High Threshold", Kind: ParamFloat, Min: 0, Max: 255, Step: 1, Default: CANNY_HIGH},
+			{Name: "operator", Label: "Operator", Kind: ParamChoice, Default: Sobel.String(), Choices: gradientOperatorNames},
+		}, borderParams()...),
+		func(src *image.RGBA, p Params) *image.RGBA {
+			op, _ := ParseGradientOperator(p.String("operator"))
+			return CannyEdges(src, p.Float("sigma"), p.Float("low"), p.Float("high"), op, borderFromParams(p))
+		}))
+}
+
+// CannyEdges returns a binary edge map, white one pixel wide edges on
+// black. The luminance is blurred with sigma (0 skips it), thinned to the
+// local maxima of the gradient magnitude along the gradient direction,
+// and edges are kept where the magnitude exceeds high or where they are
+// connected to such a pixel and exceed low.
+func CannyEdges(src *image.RGBA, sigma, low, high float64, op GradientOperator, border Border) *image.RGBA {
+	if low > high {
+		low, high = high, low
+	}
+	if sigma > 0 {
+		src = GaussianBlur(src, sigma, 0, border)
+	}
+	g, in, dst := computeGradient(src, op, border)
+	r := g.Rect
+	w := r.Dx()
+
+	mag := make([]float32, len(g.X))
+	for i := range mag {
+		mag[i] = float32(math.Hypot(float64(g.X[i]), float64(g.Y[i])))
+	}
+	magAt := func(x, y int) float32 {
+		if !image.Pt(x, y).In(r) {
+			return 0
+		}
+		return mag[g.index(x, y)]
+	}
+
+	// Non-maximum suppression, the direction is rounded to one of four
+	// neighbour pairs.
+	const (
+		none uint8 = iota
+		weak
+		strong
+	)
+	class := make([]uint8, len(mag))
+	tan22 := math.Tan(math.Pi / 8)
+	parallelRows(r, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				i := g.index(x, y)
+				m := mag[i]
+				if float64(m) < low || m == 0 {
+					continue
+				}
+				gx, gy := float64(g.X[i]), float64(g.Y[i])
+				var dx, dy int
+				switch ax, ay := math.Abs(gx), math.Abs(gy); {
+				case ay <= ax*tan22:
+					dx = 1
+				case ax <= ay*tan22:
+					dy = 1
+				case (gx > 0) == (gy > 0):
+					dx, dy = 1, 1
+				default:
+					dx, dy = 1, -1
+				}
+				// Ties are broken towards one side so that plateaus of
+				// equal magnitude still produce a single line.
+				if m < magAt(x-dx, y-dy) || m <= magAt(x+dx, y+dy) {
+					continue
+				}
+				if float64(m) >= high {
+					class[i] = strong
+				} else {
+					class[i] = weak
+				}
+			}
+		}
+	})
+
+	// Hysteresis: grow the strong pixels into 8-connected weak ones.
+	var queue []int
+	for i, c := range class {
+		if c == strong {
+			queue = append(queue, i)
+		}
+	}
+	for len(queue) > 0 {
+		i := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		x, y := i%w, i/w
+		for ny := max(0, y-1); ny <= min(r.Dy()-1, y+1); ny++ {
+			for nx := max(0, x-1); nx <= min(w-1, x+1); nx++ {
+				if n := ny*w + nx; class[n] == weak {
+					class[n] = strong
+					queue = append(queue, n)
+				}
+			}
+		}
+	}
+
+	parallelRows(r, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			d := dst.PixOffset(r.Min.X, y)
+			for x := r.Min.X; x < r.Max.X; x++ {
+				var v uint8
+				if class[g.index(x, y)] == strong {
+					v = 255
+				}
+				dst.Pix[d], dst.Pix[d+1], dst.Pix[d+2] = v, v, v
+				dst.Pix[d+3] = in.Pix[in.PixOffset(x, y)+3]
+				d += 4
+			}
+		}
+	})
+	return dst
+}
@@ -3,7 +3,6 @@ package filters
 import (
 	"image"
 	"image-filter-editor/internal/utils"
-	"image/color"
 )
 
 var (
@@ -36,55 +35,106 @@ var (
 		{-1, 1, 1},
 		{0, 1, 2},
 	}
+
+	GAUSSIAN_5X5_KERNEL = Kernel{
+		Values: [][]float64{
+			{1, 4, 6, 4, 1},
+			{4, 16, 24, 16, 4},
+			{6, 24, 36, 24, 6},
+			{4, 16, 24, 16, 4},
+			{1, 4, 6, 4, 1},
+		},
+		Anchor: image.Pt(2, 2),
+	}
+
+	MOTION_BLUR_KERNEL = Kernel{
+		Values: [][]float64{{1, 1, 1, 1, 1, 1, 1, 1, 1}},
+		Anchor: image.Pt(4, 0),
+	}
+
+	// The Laplacian sums to zero, the offset moves flat areas to mid gray
+	// so that edges of both signs stay visible.
+	LAPLACIAN_KERNEL = Kernel{
+		Values: [][]float64{
+			{0, 1, 0},
+			{1, -4, 1},
+			{0, 1, 0},
+		},
+		Anchor:  image.Pt(1, 1),
+		Divisor: 1,
+		Offset:  128,
+	}
 )
 
+func init() {
+	for _, k := range []struct {
+		rank        int
+		name, label string
+		kernel      Kernel
+	}{
+		{200, "blur", "Blur", NewKernel(BLUR_KERNEL)},
+		{205, "gaussian5", "Gaussian 5x5", GAUSSIAN_5X5_KERNEL},
+		{215, "motion", "Motion Blur", MOTION_BLUR_KERNEL},
+		{220, "sharpen", "Sharpen", NewKernel(SHARPEN_KERNEL)},
+		{230, "emboss", "Emboss", NewKernel(EMBOSS_KERNEL)},
+		{235, "edge", "Edge Detect", NewKernel(EDGE_DETECT_KERNEL)},
+		{240, "laplacian", "Laplacian", LAPLACIAN_KERNEL},
+	} {
+		kernel := k.kernel
+		Register(k.rank, NewFilter(k.name, k.label, "Convolution", borderParams(),
+			func(src *image.RGBA, p Params) *image.RGBA {
+				return ApplyKernel(src, kernel, borderFromParams(p))
+			}))
+	}
+	Register(250, NewFilter("kernel", "Custom Kernel", "Convolution",
+		append([]Param{{Name: "kernel", Label: "Kernel", Kind: ParamKernel, Default: NewKernel([][]float64{{0, 0, 0}, {0, 1, 0}, {0, 0, 0}})}}, borderParams()...),
+		func(src *image.RGBA, p Params) *image.RGBA {
+			return ApplyKernel(src, p.Kernel("kernel"), borderFromParams(p))
+		}))
+}
 
+// ApplyConvolution applies a kernel anchored at its centre without a
+// divisor or offset, the border is copied from src.
 func ApplyConvolution(src *image.RGBA, kernel [][]float64) *image.RGBA {
-	bounds := src.Bounds()
-	result := image.NewRGBA(bounds)
-	
-	kernelSize := len(kernel)
-	offset := kernelSize / 2
-
-	for y := bounds.Min.Y + offset; y < bounds.Max.Y-offset; y++ {
-		for x := bounds.Min.X + offset; x < bounds.Max.X-offset; x++ {
-			var r, g, b float64
-			
-			
-			for ky := 0; ky < kernelSize; ky++ {
-				for kx := 0; kx < kernelSize; kx++ {
-					
-					ix := x + (kx - offset)
-					iy := y + (ky - offset)
-					pixel := src.RGBAAt(ix, iy)
-					
-					
-					k := kernel[ky][kx]
-					r += float64(pixel.R) * k
-					g += float64(pixel.G) * k
-					b += float64(pixel.B) * k
-				}
-			}
-			
-			
-			result.Set(x, y, color.RGBA{
-				R: uint8(utils.Clamp(int(r), 0, 255)),
-				G: uint8(utils.Clamp(int(g), 0, 255)),
-				B: uint8(utils.Clamp(int(b), 0, 255)),
-				A: src.RGBAAt(x, y).A,
-			})
-		}
+	return ApplyKernel(src, NewKernel(kernel), Border{Mode: BorderKeep})
+}
+
+// ApplyKernel convolves the R, G and B channels with k. An invalid kernel
+// leaves the image unchanged.
+func ApplyKernel(src *image.RGBA, k Kernel, border Border) *image.RGBA {
+	if k.Validate() != nil {
+		return ApplyKernel(src, NewKernel([][]float64{{1}}), border)
 	}
 
-	
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			if y < bounds.Min.Y+offset || y >= bounds.Max.Y-offset ||
-				x < bounds.Min.X+offset || x >= bounds.Max.X-offset {
-				result.Set(x, y, src.At(x, y))
+	w, h := k.Width(), k.Height()
+	ax, ay := k.Anchor.X, k.Anchor.Y
+	divisor := k.EffectiveDivisor()
+
+	in, result, area := border.prepare(src, margins{ax, ay, w - 1 - ax, h - 1 - ay})
+	parallelRows(area, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			d := result.PixOffset(area.Min.X, y)
+			for x := area.Min.X; x < area.Max.X; x++ {
+				var r, g, b float64
+
+				for ky := 0; ky < h; ky++ {
+					row := in.PixOffset(x-ax, y+ky-ay)
+					for kx, kv := range k.Values[ky] {
+						i := row + kx*4
+						r += float64(in.Pix[i]) * kv
+						g += float64(in.Pix[i+1]) * kv
+						b += float64(in.Pix[i+2]) * kv
+					}
+				}
+
+				result.Pix[d] = uint8(utils.Clamp(int(r/divisor+k.Offset), 0, 255))
+				result.Pix[d+1] = uint8(utils.Clamp(int(g/divisor+k.Offset), 0, 255))
+				result.Pix[d+2] = uint8(utils.Clamp(int(b/divisor+k.Offset), 0, 255))
+				result.Pix[d+3] = in.Pix[in.PixOffset(x, y)+3]
+				d += 4
 			}
 		}
-	}
+	})
 
 	return result
-}
\ No newline at end of file
+}
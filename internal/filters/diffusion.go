@@ -0,0 +1,135 @@
+package filters
+
+import (
+	"image"
+	"math"
+)
+
+// DiffusionKernel selects how error diffusion spreads the quantization
+// error of a pixel over its unvisited neighbours.
+type DiffusionKernel int
+
+const (
+	FloydSteinberg DiffusionKernel = iota
+	JarvisJudiceNinke
+	Stucki
+	Burkes
+	Sierra
+	TwoRowSierra
+	SierraLite
+	// Atkinson only spreads 6/8 of the error, which keeps more contrast
+	// but loses detail in the highlights and shadows.
+	Atkinson
+)
+
+var diffusionKernelNames = []string{"floyd-steinberg", "jarvis-judice-ninke", "stucki", "burkes", "sierra", "two-row-sierra", "sierra-lite", "atkinson"}
+
+type diffusionTap struct {
+	dx, dy int
+	weight float64
+}
+
+// diffusionKernels lists the weights of every kernel for a left to right
+// scan, divided by the kernel's divisor.
+var diffusionKernels = func() [][]diffusionTap {
+	table := []struct {
+		divisor float64
+		rows    [3][]float64 // rows 0 to 2, centred on the pixel
+	}{
+		FloydSteinberg:    {16, [3][]float64{{0, 0, 0, 7, 0}, {0, 3, 5, 1, 0}}},
+		JarvisJudiceNinke: {48, [3][]float64{{0, 0, 0, 7, 5}, {3, 5, 7, 5, 3}, {1, 3, 5, 3, 1}}},
+		Stucki:            {42, [3][]float64{{0, 0, 0, 8, 4}, {2, 4, 8, 4, 2}, {1, 2, 4, 2, 1}}},
+		Burkes:            {32, [3][]float64{{0, 0, 0, 8, 4}, {2, 4, 8, 4, 2}}},
+		Sierra:            {32, [3][]float64{{0, 0, 0, 5, 3}, {2, 4, 5, 4, 2}, {0, 2, 3, 2, 0}}},
+		TwoRowSierra:      {16, [3][]float64{{0, 0, 0, 4, 3}, {1, 2, 3, 2, 1}}},
+		SierraLite:        {4, [3][]float64{{0, 0, 0, 2, 0}, {0, 1, 1, 0, 0}}},
+		Atkinson:          {8, [3][]float64{{0, 0, 0, 1, 1}, {0, 1, 1, 1, 0}, {0, 0, 1, 0, 0}}},
+	}
+	kernels := make([][]diffusionTap, len(table))
+	for k, t := range table {
+		for dy, row := range t.rows {
+			for i, w := range row {
+				if w != 0 {
+					kernels[k] = append(kernels[k], diffusionTap{i - 2, dy, w / t.divisor})
+				}
+			}
+		}
+	}
+	return kernels
+}()
+
+func (k DiffusionKernel) String() string {
+	return enumName(diffusionKernelNames, int(k), "DiffusionKernel")
+}
+
+func ParseDiffusionKernel(s string) (DiffusionKernel, error) {
+	i, err := parseName(diffusionKernelNames, s, "error diffusion kernel")
+	return DiffusionKernel(i), err
+}
+
+func init() {
+	Register(715, NewFilter("diffuse", "Error Diffusion", "Color",
+		[]Param{
+			{Name: "kernel", Label: "Kernel", Kind: ParamChoice, Default: FloydSteinberg.String(), Choices: diffusionKernelNames},
+			{Name: "red", Label: "Red Levels", Kind: ParamInt, Min: 2, Max: 256, Step: 1, Default: 2},
+			{Name: "green", Label: "Green Levels", Kind: ParamInt, Min: 2, Max: 256, Step: 1, Default: 2},
+			{Name: "blue", Label: "Blue Levels", Kind: ParamInt, Min: 2, Max: 256, Step: 1, Default: 2},
+			{Name: "serpentine", Label: "Serpentine Scan", Kind: ParamBool, Default: true},
+		},
+		func(src *image.RGBA, p Params) *image.RGBA {
+			kernel, _ := ParseDiffusionKernel(p.String("kernel"))
+			levels := [3]int{p.Int("red"), p.Int("green"), p.Int("blue")}
+			return ErrorDiffusion(src, kernel, levels, p.Bool("serpentine"))
+		}))
+}
+
+// ErrorDiffusion reduces every colour channel to levels evenly spaced
+// values, spreading the difference between a pixel and its quantized value
+// over the following pixels with kernel. With serpentine, odd rows are
+// scanned right to left with the kernel mirrored, which avoids the
+// diagonal artifacts of always scanning in one direction. Alpha is kept.
+func ErrorDiffusion(src *image.RGBA, kernel DiffusionKernel, levels [3]int, serpentine bool) *image.RGBA {
+	bounds := src.Bounds()
+	result := image.NewRGBA(bounds)
+	w := bounds.Dx()
+	if kernel < 0 || int(kernel) >= len(diffusionKernels) {
+		kernel = FloydSteinberg
+	}
+	taps := diffusionKernels[kernel]
+
+	var steps [3]float64
+	for c, n := range levels {
+		steps[c] = 255 / float64(min(max(n, 2), 256)-1)
+	}
+
+	// Errors of the current row and the two below it, with room for the
+	// kernels reaching 2 pixels past either side.
+	const pad = 2
+	stride := (w + 2*pad) * 3
+	errs := [3][]float32{make([]float32, stride), make([]float32, stride), make([]float32, stride)}
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		s := src.Pix[src.PixOffset(bounds.Min.X, y):][:w*4]
+		d := result.Pix[result.PixOffset(bounds.Min.X, y):][:w*4]
+		x0, x1, dir := 0, w, 1
+		if serpentine && (y-bounds.Min.Y)%2 == 1 {
+			x0, x1, dir = w-1, -1, -1
+		}
+		for x := x0; x != x1; x += dir {
+			e := (x + pad) * 3
+			for c := 0; c < 3; c++ {
+				v := float64(s[x*4+c]) + float64(errs[0][e+c])
+				q := math.Round(math.Min(255, math.Max(0, v))/steps[c]) * steps[c]
+				d[x*4+c] = roundChannel(q)
+				diff := float32(v - q)
+				for _, t := range taps {
+					errs[t.dy][e+(t.dx*dir)*3+c] += diff * float32(t.weight)
+				}
+			}
+			d[x*4+3] = s[x*4+3]
+		}
+		errs[0], errs[1], errs[2] = errs[1], errs[2], errs[0]
+		clear(errs[2])
+	}
+	return result
+}
@@ -0,0 +1,203 @@
+package filters
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// ThresholdMap holds the rank of every cell of an ordered dithering tile,
+// 0 being the first cell to turn on as the input gets brighter. A valid
+// map holds every rank from 0 to width*height-1 exactly once.
+type ThresholdMap [][]int
+
+// ThresholdMapKind selects how OrderedDithering builds its tile.
+type ThresholdMapKind int
+
+const (
+	// MapBayer spreads the ranks as evenly as possible, see BayerMap.
+	MapBayer ThresholdMapKind = iota
+	// MapClustered grows a single dot from the centre of the tile, which
+	// prints better on devices that cannot place isolated dots.
+	MapClustered
+	// MapBlueNoise uses a void-and-cluster mask, see BlueNoiseMap, which
+	// has no visible pattern.
+	MapBlueNoise
+	// MapCustom ranks the values of a user supplied matrix.
+	MapCustom
+)
+
+var thresholdMapKindNames = []string{"bayer", "clustered", "bluenoise", "custom"}
+
+func (k ThresholdMapKind) String() string {
+	return enumName(thresholdMapKindNames, int(k), "ThresholdMapKind")
+}
+
+func ParseThresholdMapKind(s string) (ThresholdMapKind, error) {
+	i, err := parseName(thresholdMapKindNames, s, "threshold map")
+	return ThresholdMapKind(i), err
+}
+
+// BayerMap returns the Bayer matrix of the given size, which must be a
+// power of two or three times one: 2, 3, 4, 6, 8, 12, 16 and so on. Larger
+// matrices are built recursively from the 2x2 or the standard 3x3 one.
+func BayerMap(size int) (ThresholdMap, error) {
+	switch {
+	case size == 2:
+		return ThresholdMap{{0, 2}, {3, 1}}, nil
+	case size == 3:
+		return ThresholdMap{{0, 7, 3}, {6, 5, 2}, {4, 1, 8}}, nil
+	case size < 2 || size%2 != 0:
+		return nil, fmt.Errorf("no Bayer matrix of size %d, use a power of two or three times one", size)
+	}
+	half, err := BayerMap(size / 2)
+	if err != nil {
+		return nil, fmt.Errorf("no Bayer matrix of size %d, use a power of two or three times one", size)
+	}
+	h := size / 2
+	m := make(ThresholdMap, size)
+	for y := range m {
+		m[y] = make([]int, size)
+		for x := range m[y] {
+			quadrant := [2][2]int{{0, 2}, {3, 1}}[y/h][x/h]
+			m[y][x] = 4*half[y%h][x%h] + quadrant
+		}
+	}
+	return m, nil
+}
+
+// bayerSize returns the largest size up to size that BayerMap supports.
+func bayerSize(size int) int {
+	best := 2
+	for base := 2; base <= 3; base++ {
+		for s := base; s <= size; s *= 2 {
+			best = max(best, s)
+		}
+	}
+	return best
+}
+
+// ClusteredDotMap returns a size x size map whose ranks grow outwards from
+// the centre of the tile, in rings and clockwise within a ring.
+func ClusteredDotMap(size int) ThresholdMap {
+	size = max(1, size)
+	c := float64(size-1) / 2
+	type cell struct {
+		x, y        int
+		dist, angle float64
+	}
+	cells := make([]cell, 0, size*size)
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			dx, dy := float64(x)-c, float64(y)-c
+			cells = append(cells, cell{x, y, math.Round(math.Hypot(dx, dy)*1e6) / 1e6, math.Atan2(dy, dx)})
+		}
+	}
+	sort.SliceStable(cells, func(i, j int) bool {
+		if cells[i].dist != cells[j].dist {
+			return cells[i].dist < cells[j].dist
+		}
+		return cells[i].angle < cells[j].angle
+	})
+
+	m := make(ThresholdMap, size)
+	for y := range m {
+		m[y] = make([]int, size)
+	}
+	for rank, c := range cells {
+		m[c.y][c.x] = rank
+	}
+	return m
+}
+
+// ThresholdMapFromKernel ranks the values of a matrix, so that any
+// distinct numbers can be used, e.g. a published map starting at 1.
+func ThresholdMapFromKernel(k Kernel) (ThresholdMap, error) {
+	if err := k.Validate(); err != nil {
+		return nil, err
+	}
+	w := k.Width()
+	order := make([]int, 0, w*k.Height())
+	for i := 0; i < w*k.Height(); i++ {
+		order = append(order, i)
+	}
+	value := func(i int) float64 { return k.Values[i/w][i%w] }
+	sort.SliceStable(order, func(a, b int) bool { return value(order[a]) < value(order[b]) })
+
+	m := make(ThresholdMap, k.Height())
+	for y := range m {
+		m[y] = make([]int, w)
+	}
+	for rank, i := range order {
+		if rank > 0 && value(i) == value(order[rank-1]) {
+			return nil, fmt.Errorf("threshold map value %v appears more than once", value(i))
+		}
+		m[i/w][i%w] = rank
+	}
+	return m, nil
+}
+
+// ReadThresholdMap reads a map from a file in any format ReadKernel
+// accepts.
+func ReadThresholdMap(path string) (ThresholdMap, error) {
+	k, err := ReadKernel(path)
+	if err != nil {
+		return nil, err
+	}
+	m, err := ThresholdMapFromKernel(k)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return m, nil
+}
+
+// Validate checks that the map is a non-empty rectangle holding every rank
+// from 0 to its number of cells minus one exactly once.
+func (m ThresholdMap) Validate() error {
+	if len(m) == 0 || len(m[0]) == 0 {
+		return errors.New("threshold map is empty")
+	}
+	n := len(m) * len(m[0])
+	seen := make([]bool, n)
+	for y, row := range m {
+		if len(row) != len(m[0]) {
+			return fmt.Errorf("threshold map row %d has %d values, expected %d", y+1, len(row), len(m[0]))
+		}
+		for _, v := range row {
+			if v < 0 || v >= n {
+				return fmt.Errorf("threshold map value %d is outside 0..%d", v, n-1)
+			}
+			if seen[v] {
+				return fmt.Errorf("threshold map value %d appears more than once", v)
+			}
+			seen[v] = true
+		}
+	}
+	return nil
+}
+
+// Kernel returns the ranks as a kernel, the form custom maps are stored in.
+func (m ThresholdMap) Kernel() Kernel {
+	values := make([][]float64, len(m))
+	for y, row := range m {
+		values[y] = make([]float64, len(row))
+		for x, v := range row {
+			values[y][x] = float64(v)
+		}
+	}
+	return NewKernel(values)
+}
+
+// thresholds scales the ranks to [0, 1).
+func (m ThresholdMap) thresholds() [][]float64 {
+	n := float64(len(m) * len(m[0]))
+	t := make([][]float64, len(m))
+	for y, row := range m {
+		t[y] = make([]float64, len(row))
+		for x, v := range row {
+			t[y][x] = float64(v) / n
+		}
+	}
+	return t
+}
@@ -0,0 +1,136 @@
+package filters
+
+import (
+	"image"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestBayerMap(t *testing.T) {
+	for _, size := range []int{2, 3, 4, 6, 8, 12, 16} {
+		m, err := BayerMap(size)
+		if err != nil {
+			t.Errorf("BayerMap(%d): %v", size, err)
+			continue
+		}
+		if len(m) != size || len(m[0]) != size {
+			t.Errorf("BayerMap(%d) is %dx%d", size, len(m[0]), len(m))
+		}
+		if err := m.Validate(); err != nil {
+			t.Errorf("BayerMap(%d): %v", size, err)
+		}
+	}
+}
+
+func TestBayerMapPublished(t *testing.T) {
+	for size, want := range map[int]ThresholdMap{
+		2: {{0, 2}, {3, 1}},
+		3: {{0, 7, 3}, {6, 5, 2}, {4, 1, 8}},
+	} {
+		if m, _ := BayerMap(size); !reflect.DeepEqual(m, want) {
+			t.Errorf("BayerMap(%d) = %v, want %v", size, m, want)
+		}
+	}
+}
+
+func TestBayerMapUnsupported(t *testing.T) {
+	for _, size := range []int{-1, 0, 1, 5, 10} {
+		if _, err := BayerMap(size); err == nil {
+			t.Errorf("BayerMap(%d) returned no error", size)
+		}
+	}
+}
+
+func TestClusteredDotMap(t *testing.T) {
+	for _, size := range []int{1, 2, 3, 4, 5, 8, 9, 16} {
+		m := ClusteredDotMap(size)
+		if len(m) != size || len(m[0]) != size {
+			t.Errorf("ClusteredDotMap(%d) is %dx%d", size, len(m[0]), len(m))
+		}
+		if err := m.Validate(); err != nil {
+			t.Errorf("ClusteredDotMap(%d): %v", size, err)
+		}
+	}
+}
+
+func TestThresholdMapFromKernel(t *testing.T) {
+	// A published map starting at 1 ranks the same as one starting at 0.
+	m, err := ThresholdMapFromKernel(NewKernel([][]float64{{1, 9, 3}, {7, 5, 2}, {4, 8, 6}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Validate(); err != nil {
+		t.Error(err)
+	}
+	want := ThresholdMap{{0, 8, 2}, {6, 4, 1}, {3, 7, 5}}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("got %v, want %v", m, want)
+	}
+
+	if _, err := ThresholdMapFromKernel(NewKernel([][]float64{{1, 2}, {2, 3}})); err == nil {
+		t.Error("repeated values returned no error")
+	}
+}
+
+func TestThresholdMapValidate(t *testing.T) {
+	for _, m := range []ThresholdMap{
+		nil,
+		{{}},
+		{{0, 1}, {2}},
+		{{0, 1}, {1, 3}},
+		{{0, 1}, {2, 4}},
+		{{-1, 0}, {1, 2}},
+	} {
+		if err := m.Validate(); err == nil {
+			t.Errorf("%v is valid", m)
+		}
+	}
+}
+
+func TestOrderedDitheringNegativeBounds(t *testing.T) {
+	src := image.NewRGBA(image.Rect(-5, -3, 10, 10))
+	for i := range src.Pix {
+		src.Pix[i] = uint8(i * 7)
+	}
+	m, _ := BayerMap(4)
+	all := OrderedDitheringMap(src, m, 2)
+	// The map tiles from the origin, so a part of the image dithers the
+	// same as the whole.
+	part := OrderedDitheringMap(src.SubImage(image.Rect(0, 0, 10, 10)).(*image.RGBA), m, 2)
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 10; x++ {
+			if all.RGBAAt(x, y) != part.RGBAAt(x, y) {
+				t.Fatalf("pixel %d,%d differs", x, y)
+			}
+		}
+	}
+	YCbCrDithering(src)
+}
+
+func TestOrderedDitheringLevels(t *testing.T) {
+	const levels = 4
+	step := 255.0 / (levels - 1)
+	m, _ := BayerMap(4)
+	for _, v := range []uint8{0, 40, 85, 100, 128, 200, 254, 255} {
+		src := image.NewRGBA(image.Rect(0, 0, 16, 16))
+		for i := range src.Pix {
+			src.Pix[i] = v
+		}
+		dst := OrderedDitheringMap(src, m, levels)
+		lo := uint8(math.Floor(float64(v)/step) * step)
+		hi := uint8(math.Min(255, math.Round(math.Ceil(float64(v)/step)*step)))
+		var sum float64
+		for i := 0; i < len(dst.Pix); i += 4 {
+			if c := dst.Pix[i]; c != lo && c != hi {
+				t.Fatalf("value %d dithered to %d, expected %d or %d", v, c, lo, hi)
+			}
+			sum += float64(dst.Pix[i])
+		}
+		// Every 4x4 tile has as many pixels at the upper level as the
+		// fraction of the way from lo to hi asks for, to within a cell.
+		if mean := sum / 256; math.Abs(mean-float64(v)) > step/16 {
+			t.Errorf("value %d dithered to a mean of %.1f", v, mean)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package filters
+
+import (
+	"image"
+	"math"
+)
+
+const GAUSSIAN_SIGMA = 2.0
+
+func init() {
+	Register(210, NewFilter("gaussian", "Gaussian", "Convolution",
+		append([]Param{
+			{Name: "sigma", Label: "Sigma", Kind: ParamFloat, Min: 0.1, Max: 25, Step: 0.1, Default: GAUSSIAN_SIGMA},
+			{Name: "radius", Label: "Radius (0 = 3 sigma)", Kind: ParamInt, Min: 0, Max: 75, Step: 1, Default: 0},
+		}, borderParams()...),
+		func(src *image.RGBA, p Params) *image.RGBA {
+			return GaussianBlur(src, p.Float("sigma"), p.Int("radius"), borderFromParams(p))
+		}))
+}
+
+// GaussianRadius is the radius that covers a Gaussian of the given sigma
+// up to 3 sigma, where the weights have fallen to about 1.1% of the peak.
+func GaussianRadius(sigma float64) int {
+	return max(1, int(math.Ceil(3*sigma)))
+}
+
+// GaussianKernel1D returns 2*radius+1 normalized weights of a Gaussian.
+// A radius <= 0 is derived from sigma.
+func GaussianKernel1D(sigma float64, radius int) []float64 {
+	if radius <= 0 {
+		radius = GaussianRadius(sigma)
+	}
+	weights := make([]float64, 2*radius+1)
+	var sum float64
+	for i := range weights {
+		x := float64(i - radius)
+		weights[i] = math.Exp(-x * x / (2 * sigma * sigma))
+		sum += weights[i]
+	}
+	for i := range weights {
+		weights[i] /= sum
+	}
+	return weights
+}
+
+// GaussianBlur blurs with a Gaussian as two 1-D passes, so the cost per
+// pixel grows with the radius instead of its square.
+func GaussianBlur(src *image.RGBA, sigma float64, radius int, border Border) *image.RGBA {
+	w := GaussianKernel1D(sigma, radius)
+	return ApplySeparable(src, w, w, border)
+}
+
+// ApplySeparable convolves with the 2-D kernel horizontal x vertical,
+// first along the rows and then along the columns. Both must have an odd
+// length and are anchored at their centre. The intermediate result is
+// kept in float, so only the final value is rounded to 8 bits.
+func ApplySeparable(src *image.RGBA, horizontal, vertical []float64, border Border) *image.RGBA {
+	if len(horizontal)%2 == 0 || len(vertical)%2 == 0 {
+		return ApplyKernel(src, NewKernel([][]float64{{1}}), border)
+	}
+	rx, ry := len(horizontal)/2, len(vertical)/2
+
+	in, result, area := border.prepare(src, margins{rx, ry, rx, ry})
+	if area.Empty() {
+		return result
+	}
+
+	// Horizontal pass over the rows the vertical pass reads, including
+	// ry rows above and below the area.
+	rows := image.Rect(area.Min.X, area.Min.Y-ry, area.Max.X, area.Max.Y+ry)
+	stride := area.Dx() * 3
+	tmp := make([]float32, stride*rows.Dy())
+	parallelRows(rows, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			t := tmp[(y-rows.Min.Y)*stride:][:stride]
+			for x := area.Min.X; x < area.Max.X; x++ {
+				var r, g, b float64
+				i := in.PixOffset(x-rx, y)
+				for _, k := range horizontal {
+					r += float64(in.Pix[i]) * k
+					g += float64(in.Pix[i+1]) * k
+					b += float64(in.Pix[i+2]) * k
+					i += 4
+				}
+				j := (x - area.Min.X) * 3
+				t[j], t[j+1], t[j+2] = float32(r), float32(g), float32(b)
+			}
+		}
+	})
+
+	parallelRows(area, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			d := result.PixOffset(area.Min.X, y)
+			for x := area.Min.X; x < area.Max.X; x++ {
+				var r, g, b float64
+				j := (y-ry-rows.Min.Y)*stride + (x-area.Min.X)*3
+				for _, k := range vertical {
+					r += float64(tmp[j]) * k
+					g += float64(tmp[j+1]) * k
+					b += float64(tmp[j+2]) * k
+					j += stride
+				}
+				result.Pix[d] = roundChannel(r)
+				result.Pix[d+1] = roundChannel(g)
+				result.Pix[d+2] = roundChannel(b)
+				result.Pix[d+3] = in.Pix[in.PixOffset(x, y)+3]
+				d += 4
+			}
+		}
+	})
+	return result
+}
+
+// roundChannel rounds v to the nearest channel value.
+func roundChannel(v float64) uint8 {
+	return quantize(v + 0.5)
+}
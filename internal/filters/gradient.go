@@ -0,0 +1,205 @@
+package filters
+
+import (
+	"image"
+	"math"
+)
+
+// GradientOperator selects the pair of 3x3 derivative kernels.
+type GradientOperator int
+
+const (
+	Sobel GradientOperator = iota
+	Prewitt
+	Scharr
+)
+
+var gradientOperatorNames = []string{"sobel", "prewitt", "scharr"}
+
+// gradientKernels holds the horizontal derivative of every operator,
+// the vertical one is its transpose. The weights of one side sum to
+// the divisor, so a step from 0 to 255 gives a gradient of 255.
+var gradientKernels = []struct {
+	x       [3][3]float64
+	divisor float64
+}{
+	Sobel:   {[3][3]float64{{-1, 0, 1}, {-2, 0, 2}, {-1, 0, 1}}, 4},
+	Prewitt: {[3][3]float64{{-1, 0, 1}, {-1, 0, 1}, {-1, 0, 1}}, 3},
+	Scharr:  {[3][3]float64{{-3, 0, 3}, {-10, 0, 10}, {-3, 0, 3}}, 16},
+}
+
+func (o GradientOperator) String() string {
+	return enumName(gradientOperatorNames, int(o), "GradientOperator")
+}
+
+func ParseGradientOperator(s string) (GradientOperator, error) {
+	i, err := parseName(gradientOperatorNames, s, "gradient operator")
+	return GradientOperator(i), err
+}
+
+// GradientOutput selects what GradientFilter renders.
+type GradientOutput int
+
+const (
+	// GradientMagnitude renders the length of the gradient as gray.
+	GradientMagnitude GradientOutput = iota
+	// GradientDirection renders the direction as hue, red pointing right,
+	// and the magnitude as brightness.
+	GradientDirection
+	// GradientX and GradientY render one component with 128 as zero.
+	GradientX
+	GradientY
+)
+
+var gradientOutputNames = []string{"magnitude", "direction", "x", "y"}
+
+func (o GradientOutput) String() string {
+	return enumName(gradientOutputNames, int(o), "GradientOutput")
+}
+
+func ParseGradientOutput(s string) (GradientOutput, error) {
+	i, err := parseName(gradientOutputNames, s, "gradient output")
+	return GradientOutput(i), err
+}
+
+func init() {
+	Register(300, NewFilter("gradient", "Gradient", "Edges",
+		append([]Param{
+			{Name: "operator", Label: "Operator", Kind: ParamChoice, Default: Sobel.String(), Choices: gradientOperatorNames},
+			{Name: "output", Label: "Output", Kind: ParamChoice, Default: GradientMagnitude.String(), Choices: gradientOutputNames},
+			{Name: "scale", Label: "Scale", Kind: ParamFloat, Min: 0.1, Max: 10, Step: 0.1, Default: 1.0},
+			{Name: "sigma", Label: "Pre-smoothing Sigma (0 = off)", Kind: ParamFloat, Min: 0, Max: 10, Step: 0.1, Default: 0.0},
+		}, borderParams()...),
+		func(src *image.RGBA, p Params) *image.RGBA {
+			op, _ := ParseGradientOperator(p.String("operator"))
+			out, _ := ParseGradientOutput(p.String("output"))
+			return GradientFilter(src, op, out, p.Float("scale"), p.Float("sigma"), borderFromParams(p))
+		}))
+}
+
+// GradientField holds the signed luminance derivatives of every pixel in
+// Rect, row by row, in units where a step from black to white is 255.
+type GradientField struct {
+	Rect image.Rectangle
+	X, Y []float32
+}
+
+func (g *GradientField) index(x, y int) int {
+	return (y-g.Rect.Min.Y)*g.Rect.Dx() + x - g.Rect.Min.X
+}
+
+// At returns the derivatives at pixel (x, y) of Rect.
+func (g *GradientField) At(x, y int) (gx, gy float64) {
+	i := g.index(x, y)
+	return float64(g.X[i]), float64(g.Y[i])
+}
+
+// ComputeGradient differentiates the luminance of src. The field covers
+// the whole image, unless border is BorderCrop or BorderKeep, where it
+// only covers the pixels whose neighbourhood lies inside the image.
+func ComputeGradient(src *image.RGBA, op GradientOperator, border Border) *GradientField {
+	g, _, _ := computeGradient(src, op, border)
+	return g
+}
+
+func computeGradient(src *image.RGBA, op GradientOperator, border Border) (*GradientField, *image.RGBA, *image.RGBA) {
+	in, dst, area := border.prepare(src, margins{1, 1, 1, 1})
+	if area.Empty() {
+		area = image.Rectangle{}
+	}
+	g := &GradientField{
+		Rect: area,
+		X:    make([]float32, area.Dx()*area.Dy()),
+		Y:    make([]float32, area.Dx()*area.Dy()),
+	}
+
+	k := gradientKernels[op]
+	luma := lumaPlane(in)
+	ib := in.Bounds()
+	stride := ib.Dx()
+	parallelRows(area, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := area.Min.X; x < area.Max.X; x++ {
+				var gx, gy float64
+				for j := 0; j < 3; j++ {
+					row := (y+j-1-ib.Min.Y)*stride + x - 1 - ib.Min.X
+					for i := 0; i < 3; i++ {
+						v := float64(luma[row+i])
+						gx += v * k.x[j][i]
+						gy += v * k.x[i][j]
+					}
+				}
+				n := g.index(x, y)
+				g.X[n] = float32(gx / k.divisor)
+				g.Y[n] = float32(gy / k.divisor)
+			}
+		}
+	})
+	return g, in, dst
+}
+
+// GradientFilter renders the gradient of src, optionally after a Gaussian
+// blur of sigma to suppress noise. scale multiplies the gradient before
+// it is clipped to the output range.
+func GradientFilter(src *image.RGBA, op GradientOperator, output GradientOutput, scale, sigma float64, border Border) *image.RGBA {
+	if sigma > 0 {
+		src = GaussianBlur(src, sigma, 0, border)
+	}
+	g, in, dst := computeGradient(src, op, border)
+
+	parallelRows(g.Rect, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			d := dst.PixOffset(g.Rect.Min.X, y)
+			for x := g.Rect.Min.X; x < g.Rect.Max.X; x++ {
+				gx, gy := g.At(x, y)
+				var r, gr, b uint8
+				switch output {
+				case GradientMagnitude:
+					r = roundChannel(math.Hypot(gx, gy) * scale)
+					gr, b = r, r
+				case GradientDirection:
+					hue := math.Atan2(gy, gx) * 180 / math.Pi
+					if hue < 0 {
+						hue += 360
+					}
+					value := math.Min(1, math.Hypot(gx, gy)*scale/255)
+					r, gr, b = hsvToRGB(hue, 1, value)
+				case GradientX:
+					r = roundChannel(128 + gx*scale/2)
+					gr, b = r, r
+				case GradientY:
+					r = roundChannel(128 + gy*scale/2)
+					gr, b = r, r
+				}
+				dst.Pix[d], dst.Pix[d+1], dst.Pix[d+2] = r, gr, b
+				dst.Pix[d+3] = in.Pix[in.PixOffset(x, y)+3]
+				d += 4
+			}
+		}
+	})
+	return dst
+}
+
+// hsvToRGB converts a hue in degrees and saturation and value in [0, 1].
+func hsvToRGB(h, s, v float64) (r, g, b uint8) {
+	h = math.Mod(h, 360) / 60
+	c := v * s
+	x := c * (1 - math.Abs(math.Mod(h, 2)-1))
+	var rf, gf, bf float64
+	switch int(h) {
+	case 0:
+		rf, gf = c, x
+	case 1:
+		rf, gf = x, c
+	case 2:
+		gf, bf = c, x
+	case 3:
+		gf, bf = x, c
+	case 4:
+		rf, bf = x, c
+	default:
+		rf, bf = c, x
+	}
+	m := v - c
+	return roundChannel((rf + m) * 255), roundChannel((gf + m) * 255), roundChannel((bf + m) * 255)
+}
@@ -0,0 +1,276 @@
+package filters
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Kernel is a convolution kernel of any width and height. The output pixel
+// is the weighted sum of the neighbourhood around it, divided by Divisor
+// and shifted by Offset. Anchor is the position inside Values that lines
+// up with the output pixel.
+type Kernel struct {
+	Values [][]float64
+	Anchor image.Point
+	// Divisor 0 means the sum of Values, or 1 if they sum to 0.
+	Divisor float64
+	Offset  float64
+}
+
+// NewKernel returns a kernel anchored at its centre that is applied
+// without a divisor or offset. For even sizes the anchor is the lower
+// of the two middle positions.
+func NewKernel(values [][]float64) Kernel {
+	k := Kernel{Values: cloneMatrix(values), Divisor: 1}
+	if len(values) > 0 {
+		k.Anchor = image.Pt((len(values[0])-1)/2, (len(values)-1)/2)
+	}
+	return k
+}
+
+func (k Kernel) Width() int {
+	if len(k.Values) == 0 {
+		return 0
+	}
+	return len(k.Values[0])
+}
+
+func (k Kernel) Height() int {
+	return len(k.Values)
+}
+
+// Sum returns the sum of all weights.
+func (k Kernel) Sum() float64 {
+	var sum float64
+	for _, row := range k.Values {
+		for _, v := range row {
+			sum += v
+		}
+	}
+	return sum
+}
+
+// EffectiveDivisor resolves the automatic divisor.
+func (k Kernel) EffectiveDivisor() float64 {
+	if k.Divisor != 0 {
+		return k.Divisor
+	}
+	if sum := k.Sum(); sum != 0 {
+		return sum
+	}
+	return 1
+}
+
+func (k Kernel) Clone() Kernel {
+	k.Values = cloneMatrix(k.Values)
+	return k
+}
+
+// Validate checks that the kernel is a non-empty rectangle and that the
+// anchor lies inside it.
+func (k Kernel) Validate() error {
+	w, h := k.Width(), k.Height()
+	if w == 0 {
+		return errors.New("kernel is empty")
+	}
+	for i, row := range k.Values {
+		if len(row) != w {
+			return fmt.Errorf("kernel row %d has %d values, expected %d", i+1, len(row), w)
+		}
+	}
+	if !k.Anchor.In(image.Rect(0, 0, w, h)) {
+		return fmt.Errorf("kernel anchor %d,%d is outside the %dx%d kernel", k.Anchor.X, k.Anchor.Y, w, h)
+	}
+	return nil
+}
+
+// isPlain reports whether k can be written as a bare matrix.
+func (k Kernel) isPlain() bool {
+	return k.Divisor == 1 && k.Offset == 0 && k.Anchor == NewKernel(k.Values).Anchor
+}
+
+type kernelObject struct {
+	Values  [][]float64 `json:"values" yaml:"values"`
+	Anchor  []int       `json:"anchor,omitempty" yaml:"anchor,omitempty,flow"`
+	Divisor any         `json:"divisor,omitempty" yaml:"divisor,omitempty"`
+	Offset  float64     `json:"offset,omitempty" yaml:"offset,omitempty"`
+}
+
+// marshalValue returns a bare matrix for plain kernels, so presets that
+// only use weights stay readable, and an object otherwise.
+func (k Kernel) marshalValue() any {
+	if k.isPlain() {
+		return k.Values
+	}
+	obj := kernelObject{Values: k.Values, Offset: k.Offset}
+	if k.Anchor != NewKernel(k.Values).Anchor {
+		obj.Anchor = []int{k.Anchor.X, k.Anchor.Y}
+	}
+	switch k.Divisor {
+	case 0:
+		obj.Divisor = "auto"
+	case 1:
+	default:
+		obj.Divisor = k.Divisor
+	}
+	return obj
+}
+
+func (k Kernel) MarshalJSON() ([]byte, error) {
+	return json.Marshal(k.marshalValue())
+}
+
+func (k Kernel) MarshalYAML() (any, error) {
+	return k.marshalValue(), nil
+}
+
+func (k *Kernel) UnmarshalJSON(data []byte) error {
+	var raw any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	v, err := DecodeKernel(raw)
+	if err != nil {
+		return err
+	}
+	*k = v
+	return nil
+}
+
+// DecodeKernel converts a generic JSON or YAML value into a kernel. It
+// accepts a bare matrix, or an object with values, anchor ([x, y]),
+// divisor (a number or "auto") and offset.
+func DecodeKernel(raw any) (Kernel, error) {
+	if rows, ok := raw.([]any); ok {
+		values, err := decodeMatrix(rows)
+		if err != nil {
+			return Kernel{}, err
+		}
+		k := NewKernel(values)
+		return k, k.Validate()
+	}
+
+	obj, ok := raw.(map[string]any)
+	if !ok {
+		return Kernel{}, errors.New("expected a list of rows or an object with values")
+	}
+	rows, ok := obj["values"].([]any)
+	if !ok {
+		return Kernel{}, errors.New("kernel values must be a list of rows")
+	}
+	values, err := decodeMatrix(rows)
+	if err != nil {
+		return Kernel{}, err
+	}
+	k := NewKernel(values)
+	for key, v := range obj {
+		switch key {
+		case "values":
+		case "anchor":
+			xy, ok := DecodeFloats(v)
+			if !ok || len(xy) != 2 {
+				return Kernel{}, errors.New("kernel anchor must be [x, y]")
+			}
+			k.Anchor = image.Pt(int(xy[0]), int(xy[1]))
+		case "divisor":
+			if s, ok := v.(string); ok && s == "auto" {
+				k.Divisor = 0
+			} else if k.Divisor, ok = DecodeFloat(v); !ok {
+				return Kernel{}, errors.New(`kernel divisor must be a number or "auto"`)
+			}
+		case "offset":
+			if k.Offset, ok = DecodeFloat(v); !ok {
+				return Kernel{}, errors.New("kernel offset must be a number")
+			}
+		default:
+			return Kernel{}, fmt.Errorf("unknown kernel field %q", key)
+		}
+	}
+	return k, k.Validate()
+}
+
+// ReadKernel reads a kernel file, either JSON as accepted by DecodeKernel
+// or plain text with one row per line and the weights separated by spaces
+// or commas.
+func ReadKernel(path string) (Kernel, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Kernel{}, err
+	}
+	if t := bytes.TrimSpace(data); len(t) > 0 && (t[0] == '[' || t[0] == '{') {
+		var k Kernel
+		if err := json.Unmarshal(t, &k); err != nil {
+			return Kernel{}, fmt.Errorf("%s: %w", path, err)
+		}
+		return k, nil
+	}
+
+	var values [][]float64
+	for i, line := range strings.Split(string(data), "\n") {
+		fields := strings.FieldsFunc(line, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t' || r == '\r'
+		})
+		if len(fields) == 0 {
+			continue
+		}
+		row := make([]float64, len(fields))
+		for j, f := range fields {
+			if row[j], err = strconv.ParseFloat(f, 64); err != nil {
+				return Kernel{}, fmt.Errorf("%s:%d: %q is not a number", path, i+1, f)
+			}
+		}
+		values = append(values, row)
+	}
+	k := NewKernel(values)
+	if err := k.Validate(); err != nil {
+		return Kernel{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return k, nil
+}
+
+func decodeMatrix(rows []any) ([][]float64, error) {
+	m := make([][]float64, len(rows))
+	for i, row := range rows {
+		vals, ok := DecodeFloats(row)
+		if !ok {
+			return nil, fmt.Errorf("row %d is not a list of numbers", i+1)
+		}
+		m[i] = vals
+	}
+	return m, nil
+}
+
+// DecodeFloats converts a generic JSON or YAML list of numbers.
+func DecodeFloats(v any) ([]float64, bool) {
+	list, ok := v.([]any)
+	if !ok {
+		return nil, false
+	}
+	out := make([]float64, len(list))
+	for i, item := range list {
+		if out[i], ok = DecodeFloat(item); !ok {
+			return nil, false
+		}
+	}
+	return out, true
+}
+
+// DecodeFloat converts a generic JSON or YAML number, which decodes as
+// float64 from JSON and as int from YAML.
+func DecodeFloat(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	}
+	return 0, false
+}
@@ -0,0 +1,198 @@
+package filters
+
+import (
+	"image"
+	"math"
+)
+
+// EdgeOutput selects how the LoG and DoG filters render their signed
+// response.
+type EdgeOutput int
+
+const (
+	// EdgeResponse adds 128 to the scaled response of every channel.
+	EdgeResponse EdgeOutput = iota
+	// EdgeZeroCrossings marks the pixels where the luminance response
+	// changes sign, white on black.
+	EdgeZeroCrossings
+)
+
+var edgeOutputNames = []string{"response", "zero-crossings"}
+
+func (o EdgeOutput) String() string {
+	return enumName(edgeOutputNames, int(o), "EdgeOutput")
+}
+
+func ParseEdgeOutput(s string) (EdgeOutput, error) {
+	i, err := parseName(edgeOutputNames, s, "edge output")
+	return EdgeOutput(i), err
+}
+
+func init() {
+	edgeParams := func(scale float64) []Param {
+		return append([]Param{
+			{Name: "output", Label: "Output", Kind: ParamChoice, Default: EdgeResponse.String(), Choices: edgeOutputNames},
+			{Name: "scale", Label: "Response Scale", Kind: ParamFloat, Min: 0.1, Max: 50, Step: 0.1, Default: scale},
+			{Name: "threshold", Label: "Zero-crossing Threshold", Kind: ParamFloat, Min: 0, Max: 255, Step: 1, Default: 4.0},
+		}, borderParams()...)
+	}
+	render := func(src *image.RGBA, k Kernel, p Params) *image.RGBA {
+		out, _ := ParseEdgeOutput(p.String("output"))
+		return RenderSignedKernel(src, k, out, p.Float("scale"), p.Float("threshold"), borderFromParams(p))
+	}
+
+	Register(310, NewFilter("log", "Laplacian of Gaussian", "Edges",
+		append([]Param{{Name: "sigma", Label: "Sigma", Kind: ParamFloat, Min: 0.5, Max: 10, Step: 0.1, Default: 2.0}}, edgeParams(2)...),
+		func(src *image.RGBA, p Params) *image.RGBA {
+			return render(src, LoGKernel(p.Float("sigma")), p)
+		}))
+	Register(315, NewFilter("dog", "Difference of Gaussians", "Edges",
+		append([]Param{
+			{Name: "sigma1", Label: "Inner Sigma", Kind: ParamFloat, Min: 0.3, Max: 10, Step: 0.1, Default: 1.0},
+			{Name: "sigma2", Label: "Outer Sigma", Kind: ParamFloat, Min: 0.3, Max: 20, Step: 0.1, Default: 1.6},
+		}, edgeParams(4)...),
+		func(src *image.RGBA, p Params) *image.RGBA {
+			return render(src, DoGKernel(p.Float("sigma1"), p.Float("sigma2")), p)
+		}))
+}
+
+// LoGKernel returns the negated Laplacian of a Gaussian of sigma, scaled by
+// sigma^2 so that responses are comparable across scales. Bright blobs
+// give a positive response. The weights are corrected to sum to zero,
+// so flat areas give no response despite the truncation at 3 sigma.
+func LoGKernel(sigma float64) Kernel {
+	r := GaussianRadius(sigma)
+	values := make([][]float64, 2*r+1)
+	var sum float64
+	for y := range values {
+		values[y] = make([]float64, 2*r+1)
+		for x := range values[y] {
+			d2 := float64((x-r)*(x-r) + (y-r)*(y-r))
+			s2 := sigma * sigma
+			values[y][x] = (1 - d2/(2*s2)) * math.Exp(-d2/(2*s2)) / (math.Pi * s2)
+			sum += values[y][x]
+		}
+	}
+	zeroMean(values, sum)
+	return NewKernel(values)
+}
+
+// DoGKernel returns a Gaussian of sigma1 minus one of sigma2, each
+// normalized to sum to one, which approximates LoGKernel for
+// sigma2 = 1.6 * sigma1.
+func DoGKernel(sigma1, sigma2 float64) Kernel {
+	r := GaussianRadius(math.Max(sigma1, sigma2))
+	g1 := GaussianKernel1D(sigma1, r)
+	g2 := GaussianKernel1D(sigma2, r)
+	values := make([][]float64, 2*r+1)
+	var sum float64
+	for y := range values {
+		values[y] = make([]float64, 2*r+1)
+		for x := range values[y] {
+			values[y][x] = g1[y]*g1[x] - g2[y]*g2[x]
+			sum += values[y][x]
+		}
+	}
+	zeroMean(values, sum)
+	return NewKernel(values)
+}
+
+func zeroMean(values [][]float64, sum float64) {
+	mean := sum / float64(len(values)*len(values[0]))
+	for _, row := range values {
+		for x := range row {
+			row[x] -= mean
+		}
+	}
+}
+
+// RenderSignedKernel renders a kernel with a signed response, such as
+// LoGKernel, either as 128 + scale*response per channel or as the zero
+// crossings of the luminance response whose step is at least threshold.
+func RenderSignedKernel(src *image.RGBA, k Kernel, output EdgeOutput, scale, threshold float64, border Border) *image.RGBA {
+	if output == EdgeResponse {
+		scaled := k.Clone()
+		for _, row := range scaled.Values {
+			for x := range row {
+				row[x] *= scale
+			}
+		}
+		scaled.Offset += 128
+		return ApplyKernel(src, scaled, border)
+	}
+	return ZeroCrossings(src, k, threshold, border)
+}
+
+// ZeroCrossings convolves the luminance of src with k and marks every pixel
+// whose response is non-negative while its right or lower neighbour is
+// negative, or the other way around, and the two differ by at least
+// threshold. Marking only one side keeps the lines one pixel wide.
+func ZeroCrossings(src *image.RGBA, k Kernel, threshold float64, border Border) *image.RGBA {
+	resp, rect, in, dst := lumaResponse(src, k, border)
+	w := rect.Dx()
+
+	parallelRows(rect, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			d := dst.PixOffset(rect.Min.X, y)
+			for x := rect.Min.X; x < rect.Max.X; x++ {
+				i := (y-rect.Min.Y)*w + x - rect.Min.X
+				v := resp[i]
+				edge := false
+				if x+1 < rect.Max.X {
+					edge = crosses(v, resp[i+1], threshold)
+				}
+				if !edge && y+1 < rect.Max.Y {
+					edge = crosses(v, resp[i+w], threshold)
+				}
+				var c uint8
+				if edge {
+					c = 255
+				}
+				dst.Pix[d], dst.Pix[d+1], dst.Pix[d+2] = c, c, c
+				dst.Pix[d+3] = in.Pix[in.PixOffset(x, y)+3]
+				d += 4
+			}
+		}
+	})
+	return dst
+}
+
+func crosses(a, b float32, threshold float64) bool {
+	return (a >= 0) != (b >= 0) && math.Abs(float64(a-b)) >= threshold
+}
+
+// lumaResponse convolves the luminance of src with k in float, ignoring
+// the kernel offset. It returns the response of every pixel in rect, row
+// by row, along with the images prepared by border.
+func lumaResponse(src *image.RGBA, k Kernel, border Border) (resp []float32, rect image.Rectangle, in, dst *image.RGBA) {
+	if k.Validate() != nil {
+		k = NewKernel([][]float64{{1}})
+	}
+	w, h := k.Width(), k.Height()
+	ax, ay := k.Anchor.X, k.Anchor.Y
+	divisor := k.EffectiveDivisor()
+
+	in, dst, rect = border.prepare(src, margins{ax, ay, w - 1 - ax, h - 1 - ay})
+	if rect.Empty() {
+		rect = image.Rectangle{}
+	}
+	resp = make([]float32, rect.Dx()*rect.Dy())
+	luma := lumaPlane(in)
+	ib := in.Bounds()
+
+	parallelRows(rect, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := rect.Min.X; x < rect.Max.X; x++ {
+				var sum float64
+				for ky, row := range k.Values {
+					l := luma[(y+ky-ay-ib.Min.Y)*ib.Dx()+x-ax-ib.Min.X:]
+					for kx, kv := range row {
+						sum += float64(l[kx]) * kv
+					}
+				}
+				resp[(y-rect.Min.Y)*rect.Dx()+x-rect.Min.X] = float32(sum / divisor)
+			}
+		}
+	})
+	return resp, rect, in, dst
+}
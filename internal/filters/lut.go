@@ -0,0 +1,89 @@
+package filters
+
+import "image"
+
+// Curve maps a channel value to a new one. Values are not limited to
+// [0, 255] so that several curves can be chained without clipping.
+type Curve func(x float64) float64
+
+// PointFilter is a filter that maps every channel value independently of
+// its neighbours. Adjacent point filters in a stack can be fused with
+// ComposeCurves and applied in a single pass.
+type PointFilter interface {
+	Filter
+	Curve(p Params) Curve
+}
+
+type pointFilter struct {
+	funcFilter
+	curve func(p Params) Curve
+}
+
+// NewPointFilter registers a filter by its curve, Apply maps the image
+// through a lookup table built from it.
+func NewPointFilter(name, label, category string, params []Param, curve func(p Params) Curve) Filter {
+	f := &pointFilter{curve: curve}
+	f.funcFilter = funcFilter{name, label, category, params, func(src *image.RGBA, p Params) *image.RGBA {
+		return ApplyCurve(src, curve(p))
+	}}
+	return f
+}
+
+func (f *pointFilter) Curve(p Params) Curve {
+	return f.curve(Resolve(f, p))
+}
+
+// ComposeCurves returns a curve that applies curves in order. The
+// intermediate values stay in float, only the final result is quantized.
+func ComposeCurves(curves ...Curve) Curve {
+	curves = append([]Curve(nil), curves...)
+	return func(x float64) float64 {
+		for _, c := range curves {
+			x = c(x)
+		}
+		return x
+	}
+}
+
+// ApplyCurve maps the R, G and B channels of src through c.
+func ApplyCurve(src *image.RGBA, c Curve) *image.RGBA {
+	var lut [256]uint8
+	for i := range lut {
+		lut[i] = quantize(c(float64(i)))
+	}
+	return applyLUT(src, &lut)
+}
+
+// quantize truncates v to a channel value the same way the filters did
+// before they were expressed as curves.
+func quantize(v float64) uint8 {
+	if !(v > 0) {
+		return 0
+	}
+	if v >= 255 {
+		return 255
+	}
+	return uint8(v)
+}
+
+// applyLUT maps the R, G and B channels of every pixel through lut,
+// alpha is copied unchanged.
+func applyLUT(src *image.RGBA, lut *[256]uint8) *image.RGBA {
+	bounds := src.Bounds()
+	result := image.NewRGBA(bounds)
+	width := bounds.Dx() * 4
+
+	parallelRows(bounds, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			s := src.Pix[src.PixOffset(bounds.Min.X, y):][:width]
+			d := result.Pix[result.PixOffset(bounds.Min.X, y):][:width]
+			for i := 0; i < width; i += 4 {
+				d[i] = lut[s[i]]
+				d[i+1] = lut[s[i+1]]
+				d[i+2] = lut[s[i+2]]
+				d[i+3] = s[i+3]
+			}
+		}
+	})
+	return result
+}
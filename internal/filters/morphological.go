@@ -2,81 +2,160 @@ package filters
 
 import (
 	"image"
-	"image/color"
 )
 
-func DilateImage(src *image.RGBA) *image.RGBA {
-	bounds := src.Bounds()
-	result := image.NewRGBA(bounds)
+// MorphOp selects a morphological operation built from dilation and
+// erosion.
+type MorphOp int
 
+const (
+	MorphDilate MorphOp = iota
+	MorphErode
+	// MorphOpen erodes then dilates, removing bright details smaller than
+	// the element.
+	MorphOpen
+	// MorphClose dilates then erodes, filling dark details smaller than
+	// the element.
+	MorphClose
+	// MorphGradient is the dilation minus the erosion, bright on edges.
+	MorphGradient
+	// MorphTopHat is the image minus its opening, the bright details.
+	MorphTopHat
+	// MorphBlackHat is the closing minus the image, the dark details.
+	MorphBlackHat
+)
 
-	for y := bounds.Min.Y + 1; y < bounds.Max.Y-1; y++ {
-			for x := bounds.Min.X + 1; x < bounds.Max.X-1; x++ {
-					var maxR, maxG, maxB uint8
-					a := src.RGBAAt(x, y).A
-				
-					for j := -1; j <= 1; j++ {
-							for i := -1; i <= 1; i++ {
-									p := src.RGBAAt(x+i, y+j)
-									if p.R > maxR {
-											maxR = p.R
-									}
-									if p.G > maxG {
-											maxG = p.G
-									}
-									if p.B > maxB {
-											maxB = p.B
-									}
-							}
-					}
-					result.Set(x, y, color.RGBA{maxR, maxG, maxB, a})
-			}
+// morphOpNames are also the names the operations are registered under.
+var (
+	morphOpNames  = []string{"dilate", "erode", "opening", "closing", "morphgradient", "tophat", "blackhat"}
+	morphOpLabels = []string{"Dilation", "Erosion", "Opening", "Closing", "Morphological Gradient", "Top-hat", "Black-hat"}
+)
+
+func (o MorphOp) String() string {
+	return enumName(morphOpNames, int(o), "MorphOp")
+}
+
+func ParseMorphOp(s string) (MorphOp, error) {
+	i, err := parseName(morphOpNames, s, "morphological operation")
+	return MorphOp(i), err
+}
+
+func init() {
+	for op := range MorphOp(len(morphOpNames)) {
+		params := append(elementParams(), Param{Name: "iterations", Label: "Iterations", Kind: ParamInt, Min: 1, Max: 20, Step: 1, Default: 1})
+		Register(500+5*int(op), NewFilter(op.String(), morphOpLabels[op], "Morphology", append(params, borderParams()...),
+			func(src *image.RGBA, p Params) *image.RGBA {
+				return Morphology(src, op, ElementFromParams(p), p.Int("iterations"), borderFromParams(p))
+			}))
 	}
-	copyBorder(src, result)
-	return result
 }
 
+// DilateImage dilates with a 3x3 square and copies the border from src.
+func DilateImage(src *image.RGBA) *image.RGBA {
+	return Dilate(src, NewElement(ElementSquare, 1, 0), Border{Mode: BorderKeep})
+}
+
+// ErodeImage erodes with a 3x3 square and copies the border from src.
 func ErodeImage(src *image.RGBA) *image.RGBA {
-	bounds := src.Bounds()
-	result := image.NewRGBA(bounds)
+	return Erode(src, NewElement(ElementSquare, 1, 0), Border{Mode: BorderKeep})
+}
 
+// Dilate replaces every channel by its maximum over the element reflected
+// through its origin, so that a single bright pixel grows into a copy of
+// the element.
+func Dilate(src *image.RGBA, se StructuringElement, border Border) *image.RGBA {
+	return rankExtreme(src, se.Reflect(), true, border)
+}
 
-	for y := bounds.Min.Y + 1; y < bounds.Max.Y-1; y++ {
-			for x := bounds.Min.X + 1; x < bounds.Max.X-1; x++ {
-					minR, minG, minB := uint8(255), uint8(255), uint8(255)
-					a := src.RGBAAt(x, y).A
-				
-					for j := -1; j <= 1; j++ {
-							for i := -1; i <= 1; i++ {
-									p := src.RGBAAt(x+i, y+j)
-									if p.R < minR {
-											minR = p.R
-									}
-									if p.G < minG {
-											minG = p.G
-									}
-									if p.B < minB {
-											minB = p.B
-									}
-							}
-					}
-					result.Set(x, y, color.RGBA{minR, minG, minB, a})
-			}
-	}
-	copyBorder(src, result)
-	return result
+// Erode replaces every channel by its minimum over the element.
+func Erode(src *image.RGBA, se StructuringElement, border Border) *image.RGBA {
+	return rankExtreme(src, se, false, border)
 }
 
-func copyBorder(src, dst *image.RGBA) {
-	bounds := src.Bounds()
+// Morphology applies op, repeating every dilation and erosion iterations
+// times: an opening of two iterations erodes twice, then dilates twice.
+// The differences of MorphGradient, MorphTopHat and MorphBlackHat cover
+// the pixels present in both operands, which is all of them unless border
+// is BorderCrop.
+func Morphology(src *image.RGBA, op MorphOp, se StructuringElement, iterations int, border Border) *image.RGBA {
+	iterations = max(1, iterations)
+	repeat := func(img *image.RGBA, f func(*image.RGBA, StructuringElement, Border) *image.RGBA) *image.RGBA {
+		for i := 0; i < iterations; i++ {
+			img = f(img, se, border)
+		}
+		return img
+	}
 
-	for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			dst.Set(x, bounds.Min.Y, src.At(x, bounds.Min.Y))
-			dst.Set(x, bounds.Max.Y-1, src.At(x, bounds.Max.Y-1))
+	switch op {
+	case MorphErode:
+		return repeat(src, Erode)
+	case MorphOpen:
+		return repeat(repeat(src, Erode), Dilate)
+	case MorphClose:
+		return repeat(repeat(src, Dilate), Erode)
+	case MorphGradient:
+		return subtract(repeat(src, Dilate), repeat(src, Erode))
+	case MorphTopHat:
+		return subtract(src, repeat(repeat(src, Erode), Dilate))
+	case MorphBlackHat:
+		return subtract(repeat(repeat(src, Dilate), Erode), src)
 	}
+	return repeat(src, Dilate)
+}
 
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-			dst.Set(bounds.Min.X, y, src.At(bounds.Min.X, y))
-			dst.Set(bounds.Max.X-1, y, src.At(bounds.Max.X-1, y))
+// subtract returns a - b per colour channel, clipped at 0, over the
+// intersection of both bounds. Alpha is taken from a.
+func subtract(a, b *image.RGBA) *image.RGBA {
+	bounds := a.Bounds().Intersect(b.Bounds())
+	result := image.NewRGBA(bounds)
+	parallelRows(bounds, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			pa := a.Pix[a.PixOffset(bounds.Min.X, y):][:bounds.Dx()*4]
+			pb := b.Pix[b.PixOffset(bounds.Min.X, y):][:bounds.Dx()*4]
+			d := result.Pix[result.PixOffset(bounds.Min.X, y):][:bounds.Dx()*4]
+			for i := 0; i < len(d); i += 4 {
+				for c := 0; c < 3; c++ {
+					d[i+c] = pa[i+c] - min(pa[i+c], pb[i+c])
+				}
+				d[i+3] = pa[i+3]
+			}
+		}
+	})
+	return result
+}
+
+// rankExtreme replaces every channel by the maximum (dilate) or minimum
+// value of the pixels covered by se placed at its origin. Alpha is kept.
+func rankExtreme(src *image.RGBA, se StructuringElement, dilate bool, border Border) *image.RGBA {
+	if se.Validate() != nil {
+		se = NewElement(ElementSquare, 0, 0)
 	}
+	in, result, area := border.prepare(src, se.margins())
+	offs := se.offsets(in.Stride)
+	parallelRows(area, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			d := result.PixOffset(area.Min.X, y)
+			for x := area.Min.X; x < area.Max.X; x++ {
+				c := in.PixOffset(x, y)
+				var r, g, b uint8
+				if !dilate {
+					r, g, b = 255, 255, 255
+				}
+				for _, o := range offs {
+					p := in.Pix[c+o : c+o+3 : c+o+3]
+					if dilate {
+						r, g, b = max(r, p[0]), max(g, p[1]), max(b, p[2])
+					} else {
+						r, g, b = min(r, p[0]), min(g, p[1]), min(b, p[2])
+					}
+				}
+				result.Pix[d] = r
+				result.Pix[d+1] = g
+				result.Pix[d+2] = b
+				result.Pix[d+3] = in.Pix[c+3]
+				d += 4
+			}
+		}
+	})
+	return result
 }
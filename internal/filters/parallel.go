@@ -0,0 +1,51 @@
+package filters
+
+import (
+	"image"
+	"runtime"
+	"sync"
+)
+
+// Images smaller than this are processed on the calling goroutine, the
+// cost of starting workers would outweigh the gain.
+const minParallelPixels = 64 * 64
+
+// parallelRows splits the rows of r into bands and calls fn for every band
+// on GOMAXPROCS workers. fn must only write to rows in [y0, y1), which makes
+// the result identical to calling fn(r.Min.Y, r.Max.Y) sequentially.
+func parallelRows(r image.Rectangle, fn func(y0, y1 int)) {
+	h := r.Dy()
+	if h <= 0 || r.Dx() <= 0 {
+		return
+	}
+	workers := runtime.GOMAXPROCS(0)
+	if workers < 2 || r.Dx()*h < minParallelPixels {
+		fn(r.Min.Y, r.Max.Y)
+		return
+	}
+
+	// A few bands per worker keep everyone busy when rows differ in cost.
+	bands := workers * 4
+	if bands > h {
+		bands = h
+	}
+	bandHeight := (h + bands - 1) / bands
+
+	jobs := make(chan [2]int, bands)
+	for y0 := r.Min.Y; y0 < r.Max.Y; y0 += bandHeight {
+		jobs <- [2]int{y0, min(y0+bandHeight, r.Max.Y)}
+	}
+	close(jobs)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for band := range jobs {
+				fn(band[0], band[1])
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,56 @@
+package filters
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"runtime"
+	"testing"
+)
+
+// TestParallelMatchesSequential runs every filter with its defaults and
+// with every value of its choice parameters, once on a single worker and
+// once on several, and expects the same bytes.
+func TestParallelMatchesSequential(t *testing.T) {
+	// Blue noise masks are cached under the user cache directory.
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+
+	// Large enough for parallelRows to split it into bands.
+	src := testImage(image.Rect(0, 0, 97, 83))
+	if src.Bounds().Dx()*src.Bounds().Dy() < minParallelPixels {
+		t.Fatal("test image is too small to be processed in parallel")
+	}
+
+	type run struct {
+		name   string
+		filter Filter
+		params Params
+	}
+	var runs []run
+	for _, f := range All() {
+		runs = append(runs, run{f.Name(), f, Defaults(f)})
+		for _, param := range f.Params() {
+			if param.Kind != ParamChoice {
+				continue
+			}
+			for _, choice := range param.Choices {
+				runs = append(runs, run{fmt.Sprintf("%s/%s=%s", f.Name(), param.Name, choice), f, Params{param.Name: choice}})
+			}
+		}
+	}
+
+	prev := runtime.GOMAXPROCS(1)
+	defer runtime.GOMAXPROCS(prev)
+	want := make([][]byte, len(runs))
+	for i, r := range runs {
+		want[i] = r.filter.Apply(src, r.params).Pix
+	}
+
+	runtime.GOMAXPROCS(8)
+	for i, r := range runs {
+		if got := r.filter.Apply(src, r.params).Pix; !bytes.Equal(got, want[i]) {
+			t.Errorf("%s: parallel result differs from the sequential one", r.name)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package filters
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"image-filter-editor/internal/utils"
+)
+
+// The reference implementations below go through At and Set like the
+// filters did before they accessed the Pix buffers directly. The
+// benchmarks compare both, the test checks that they agree.
+
+func refInvert(src *image.RGBA) *image.RGBA {
+	bounds := src.Bounds()
+	result := image.NewRGBA(bounds)
+	parallelRows(bounds, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				r, g, b, a := src.At(x, y).RGBA()
+				result.Set(x, y, color.RGBA{255 - uint8(r>>8), 255 - uint8(g>>8), 255 - uint8(b>>8), uint8(a >> 8)})
+			}
+		}
+	})
+	return result
+}
+
+func refBrightness(src *image.RGBA, factor int) *image.RGBA {
+	bounds := src.Bounds()
+	result := image.NewRGBA(bounds)
+	parallelRows(bounds, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				r, g, b, a := src.At(x, y).RGBA()
+				result.Set(x, y, color.RGBA{
+					uint8(utils.Clamp(int(r>>8)+factor, 0, 255)),
+					uint8(utils.Clamp(int(g>>8)+factor, 0, 255)),
+					uint8(utils.Clamp(int(b>>8)+factor, 0, 255)),
+					uint8(a >> 8),
+				})
+			}
+		}
+	})
+	return result
+}
+
+func refConvolution(src *image.RGBA, kernel [][]float64) *image.RGBA {
+	bounds := src.Bounds()
+	result := image.NewRGBA(bounds)
+	offset := len(kernel) / 2
+	inner := bounds.Inset(offset)
+	parallelRows(bounds, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				if !image.Pt(x, y).In(inner) {
+					result.Set(x, y, src.At(x, y))
+					continue
+				}
+				var r, g, b float64
+				for ky, row := range kernel {
+					for kx, k := range row {
+						p := src.RGBAAt(x+kx-offset, y+ky-offset)
+						r += float64(p.R) * k
+						g += float64(p.G) * k
+						b += float64(p.B) * k
+					}
+				}
+				result.Set(x, y, color.RGBA{
+					uint8(utils.Clamp(int(r), 0, 255)),
+					uint8(utils.Clamp(int(g), 0, 255)),
+					uint8(utils.Clamp(int(b), 0, 255)),
+					src.RGBAAt(x, y).A,
+				})
+			}
+		}
+	})
+	return result
+}
+
+func refDilate(src *image.RGBA) *image.RGBA {
+	bounds := src.Bounds()
+	result := image.NewRGBA(bounds)
+	inner := bounds.Inset(1)
+	parallelRows(bounds, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				if !image.Pt(x, y).In(inner) {
+					result.Set(x, y, src.At(x, y))
+					continue
+				}
+				m := color.RGBA{A: src.RGBAAt(x, y).A}
+				for j := -1; j <= 1; j++ {
+					for i := -1; i <= 1; i++ {
+						p := src.RGBAAt(x+i, y+j)
+						m.R, m.G, m.B = max(m.R, p.R), max(m.G, p.G), max(m.B, p.B)
+					}
+				}
+				result.Set(x, y, m)
+			}
+		}
+	})
+	return result
+}
+
+var sharpen = [][]float64{{0, -1, 0}, {-1, 5, -1}, {0, -1, 0}}
+
+// pixCases pairs every filter with its reference implementation.
+var pixCases = []struct {
+	name     string
+	pix, ref func(*image.RGBA) *image.RGBA
+}{
+	{"Invert", InvertImage, refInvert},
+	{"Brightness",
+		func(src *image.RGBA) *image.RGBA { return BrightnessCorrection(src, BRIGHTNESS_FACTOR) },
+		func(src *image.RGBA) *image.RGBA { return refBrightness(src, BRIGHTNESS_FACTOR) }},
+	{"Convolution",
+		func(src *image.RGBA) *image.RGBA { return ApplyConvolution(src, sharpen) },
+		func(src *image.RGBA) *image.RGBA { return refConvolution(src, sharpen) }},
+	{"Dilation", DilateImage, refDilate},
+}
+
+// testImage returns an image over r with gradients in every channel.
+func testImage(r image.Rectangle) *image.RGBA {
+	img := image.NewRGBA(r)
+	w, h := r.Dx(), r.Dy()
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			i, j := x-r.Min.X, y-r.Min.Y
+			img.SetRGBA(x, y, color.RGBA{uint8(i * 255 / w), uint8(j * 255 / h), uint8((i ^ j) * 7), uint8(255 - (i+j)%64)})
+		}
+	}
+	return img
+}
+
+func TestPixMatchesReference(t *testing.T) {
+	// Filters index Pix with PixOffset, which has to work for images not
+	// starting at 0,0 and for sub-images whose stride is wider than them.
+	srcs := map[string]*image.RGBA{
+		"origin": testImage(image.Rect(0, 0, 67, 41)),
+		"offset": testImage(image.Rect(-13, 7, 54, 48)),
+		"sub":    testImage(image.Rect(0, 0, 90, 60)).SubImage(image.Rect(11, 5, 78, 46)).(*image.RGBA),
+	}
+	for name, src := range srcs {
+		for _, c := range pixCases {
+			got, want := c.pix(src), c.ref(src)
+			if got.Bounds() != want.Bounds() {
+				t.Errorf("%s %s: bounds %v, reference %v", c.name, name, got.Bounds(), want.Bounds())
+				continue
+			}
+			for i := range want.Pix {
+				if got.Pix[i] != want.Pix[i] {
+					t.Errorf("%s %s: byte %d is %d, reference %d", c.name, name, i, got.Pix[i], want.Pix[i])
+					break
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkPix(b *testing.B) {
+	src := testImage(image.Rect(0, 0, 3000, 2000))
+	for _, c := range pixCases {
+		b.Run(c.name+"/Pix", func(b *testing.B) {
+			for b.Loop() {
+				c.pix(src)
+			}
+		})
+		b.Run(c.name+"/AtSet", func(b *testing.B) {
+			for b.Loop() {
+				c.ref(src)
+			}
+		})
+	}
+}
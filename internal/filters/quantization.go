@@ -4,209 +4,258 @@ import (
 	"image"
 	"image-filter-editor/internal/utils"
 	"image/color"
+	"math"
 	"sort"
+	"strconv"
+	"sync"
 )
 
+func init() {
+	Register(700, NewFilter("grayscale", "Grayscale", "Color", nil,
+		func(src *image.RGBA, p Params) *image.RGBA {
+			return ToGrayscale(src)
+		}))
+	bayer4, _ := BayerMap(4)
+	Register(710, NewFilter("dither", "Dithering", "Color",
+		[]Param{
+			{Name: "levels", Label: "Dither Levels", Kind: ParamInt, Min: 2, Max: 8, Step: 1, Default: 2},
+			{Name: "size", Label: "Dither Map Size (Bayer, clustered)", Kind: ParamInt, Min: 2, Max: 256, Step: 1, Default: 2},
+			{Name: "map", Label: "Threshold Map", Kind: ParamChoice, Default: MapBayer.String(), Choices: thresholdMapKindNames},
+			{Name: "noise", Label: "Blue Noise Size", Kind: ParamChoice, Default: "64", Choices: blueNoiseSizes},
+			{Name: "custom", Label: "Custom Map (ranks)", Kind: ParamKernel, Default: bayer4.Kernel()},
+		},
+		func(src *image.RGBA, p Params) *image.RGBA {
+			kind, _ := ParseThresholdMapKind(p.String("map"))
+			switch kind {
+			case MapClustered:
+				return OrderedDitheringMap(src, ClusteredDotMap(p.Int("size")), p.Int("levels"))
+			case MapBlueNoise:
+				size, _ := strconv.Atoi(p.String("noise"))
+				if m, err := BlueNoiseMap(size); err == nil {
+					return OrderedDitheringMap(src, m, p.Int("levels"))
+				}
+			case MapCustom:
+				if m, err := ThresholdMapFromKernel(p.Kernel("custom")); err == nil {
+					return OrderedDitheringMap(src, m, p.Int("levels"))
+				}
+			}
+			return OrderedDithering(src, p.Int("size"), p.Int("levels"))
+		}))
+	Register(705, NewFilter("quantize", "Quantize Colors", "Color",
+		[]Param{{Name: "colors", Label: "Number of Colors", Kind: ParamInt, Min: 2, Max: 256, Step: 1, Default: 16}},
+		func(src *image.RGBA, p Params) *image.RGBA {
+			return PopularityQuantization(src, p.Int("colors"))
+		}))
+	Register(720, NewFilter("ycbcr", "YCbCr + Dithering", "Color", nil,
+		func(src *image.RGBA, p Params) *image.RGBA {
+			return YCbCrDithering(src)
+		}))
+}
+
 func ToGrayscale(src *image.RGBA) *image.RGBA {
-    bounds := src.Bounds()
-    result := image.NewRGBA(bounds)
-    
-    for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-        for x := bounds.Min.X; x < bounds.Max.X; x++ {
-            originalColor := src.At(x, y)
-            r, g, b, _ := originalColor.RGBA()
-            
-          
-            gray := uint8((0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)) / 256.0)
-            result.Set(x, y, color.RGBA{gray, gray, gray, 255})
-        }
-    }
-    return result
+	bounds := src.Bounds()
+	result := image.NewRGBA(bounds)
+	width := bounds.Dx() * 4
+
+	parallelRows(bounds, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			s := src.Pix[src.PixOffset(bounds.Min.X, y):][:width]
+			d := result.Pix[result.PixOffset(bounds.Min.X, y):][:width]
+			for i := 0; i < width; i += 4 {
+				// 16-bit channels as returned by color.RGBA.RGBA()
+				r := float64(s[i]) * 0x101
+				g := float64(s[i+1]) * 0x101
+				b := float64(s[i+2]) * 0x101
+
+				gray := uint8((0.299*r + 0.587*g + 0.114*b) / 256.0)
+				d[i], d[i+1], d[i+2], d[i+3] = gray, gray, gray, 255
+			}
+		}
+	})
+	return result
 }
 
+// OrderedDithering dithers with a Bayer matrix of mapSize, or of the
+// largest size below it that BayerMap supports.
 func OrderedDithering(src *image.RGBA, mapSize int, levels int) *image.RGBA {
-    bounds := src.Bounds()
-    result := image.NewRGBA(bounds)
-    
-  
-    thresholdMap := makeThresholdMap(mapSize)
-    
-    step := 255.0 / float64(levels-1)
-    
-    for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-        for x := bounds.Min.X; x < bounds.Max.X; x++ {
-            originalColor := src.At(x, y)
-            r, g, b, a := originalColor.RGBA()
-            
-          
-            threshold := thresholdMap[(y%mapSize)][(x%mapSize)]
-            
-          
-            newR := ditherValue(uint8(r>>8), threshold, step)
-            newG := ditherValue(uint8(g>>8), threshold, step)
-            newB := ditherValue(uint8(b>>8), threshold, step)
-            
-            result.Set(x, y, color.RGBA{newR, newG, newB, uint8(a>>8)})
-        }
-    }
-    return result
+	m, _ := BayerMap(bayerSize(mapSize))
+	return OrderedDitheringMap(src, m, levels)
 }
 
-func PopularityQuantization(src *image.RGBA, numColors int) *image.RGBA {
-    bounds := src.Bounds()
-    result := image.NewRGBA(bounds)
-    
-  
-    colorCount := make(map[color.RGBA]int)
-    for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-        for x := bounds.Min.X; x < bounds.Max.X; x++ {
-            c := src.RGBAAt(x, y)
-            colorCount[c]++
-        }
-    }
-    
-  
-    type colorFreq struct {
-        color color.RGBA
-        count int
-    }
-    
-    var frequencies []colorFreq
-    for c, count := range colorCount {
-        frequencies = append(frequencies, colorFreq{c, count})
-    }
-    
-    sort.Slice(frequencies, func(i, j int) bool {
-        return frequencies[i].count > frequencies[j].count
-    })
-    
-  
-    palette := make([]color.RGBA, 0, numColors)
-    for i := 0; i < numColors && i < len(frequencies); i++ {
-        palette = append(palette, frequencies[i].color)
-    }
-    
-  
-    for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-        for x := bounds.Min.X; x < bounds.Max.X; x++ {
-            original := src.RGBAAt(x, y)
-            nearest := findNearestColor(original, palette)
-            result.Set(x, y, nearest)
-        }
-    }
-    
-    return result
+// OrderedDitheringMap dithers with any valid threshold map, tiled over the
+// image.
+func OrderedDitheringMap(src *image.RGBA, m ThresholdMap, levels int) *image.RGBA {
+	bounds := src.Bounds()
+	result := image.NewRGBA(bounds)
+	width := bounds.Dx() * 4
+
+	if m.Validate() != nil {
+		m = ThresholdMap{{0}}
+	}
+	thresholdMap := m.thresholds()
+	mapWidth, mapHeight := len(m[0]), len(m)
+
+	step := 255.0 / float64(levels-1)
+
+	parallelRows(bounds, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			s := src.Pix[src.PixOffset(bounds.Min.X, y):][:width]
+			d := result.Pix[result.PixOffset(bounds.Min.X, y):][:width]
+			// The map tiles from the origin, also into negative
+			// coordinates.
+			row := thresholdMap[((y%mapHeight)+mapHeight)%mapHeight]
+			for i, x := 0, bounds.Min.X; i < width; i, x = i+4, x+1 {
+				threshold := row[((x%mapWidth)+mapWidth)%mapWidth]
+
+				d[i] = ditherValue(s[i], threshold, step)
+				d[i+1] = ditherValue(s[i+1], threshold, step)
+				d[i+2] = ditherValue(s[i+2], threshold, step)
+				d[i+3] = s[i+3]
+			}
+		}
+	})
+	return result
 }
 
-func makeThresholdMap(size int) [][]float64 {
-//   FIXME: Use static values
-    basic := [][]float64{
-        {0.0, 0.5},
-        {0.75, 0.25},
-    }
-    
-    if size == 2 {
-        return basic
-    }
-    
-  
-    result := make([][]float64, size)
-    for i := range result {
-        result[i] = make([]float64, size)
-    }
-    
-  
-    scale := float64(size * size)
-    for y := 0; y < size; y++ {
-        for x := 0; x < size; x++ {
-            result[y][x] = float64(((x^y)*size + x) % (size*size)) / scale
-        }
-    }
-    
-    return result
+func PopularityQuantization(src *image.RGBA, numColors int) *image.RGBA {
+	bounds := src.Bounds()
+	result := image.NewRGBA(bounds)
+	width := bounds.Dx() * 4
+
+	colorCount := make(map[color.RGBA]int)
+	var mu sync.Mutex
+	parallelRows(bounds, func(y0, y1 int) {
+		local := make(map[color.RGBA]int)
+		for y := y0; y < y1; y++ {
+			s := src.Pix[src.PixOffset(bounds.Min.X, y):][:width]
+			for i := 0; i < width; i += 4 {
+				local[color.RGBA{s[i], s[i+1], s[i+2], s[i+3]}]++
+			}
+		}
+		mu.Lock()
+		for c, n := range local {
+			colorCount[c] += n
+		}
+		mu.Unlock()
+	})
+
+	type colorFreq struct {
+		color color.RGBA
+		count int
+	}
+
+	var frequencies []colorFreq
+	for c, count := range colorCount {
+		frequencies = append(frequencies, colorFreq{c, count})
+	}
+
+	// Ties are broken by colour value so the palette does not depend on
+	// map iteration order.
+	sort.Slice(frequencies, func(i, j int) bool {
+		if frequencies[i].count != frequencies[j].count {
+			return frequencies[i].count > frequencies[j].count
+		}
+		return packRGBA(frequencies[i].color) < packRGBA(frequencies[j].color)
+	})
+
+	palette := make([]color.RGBA, 0, numColors)
+	for i := 0; i < numColors && i < len(frequencies); i++ {
+		palette = append(palette, frequencies[i].color)
+	}
+
+	parallelRows(bounds, func(y0, y1 int) {
+		// Photos repeat colours a lot, so remember the nearest palette
+		// entry instead of searching the palette for every pixel.
+		nearestCache := make(map[color.RGBA]color.RGBA)
+		for y := y0; y < y1; y++ {
+			s := src.Pix[src.PixOffset(bounds.Min.X, y):][:width]
+			d := result.Pix[result.PixOffset(bounds.Min.X, y):][:width]
+			for i := 0; i < width; i += 4 {
+				original := color.RGBA{s[i], s[i+1], s[i+2], s[i+3]}
+				nearest, ok := nearestCache[original]
+				if !ok {
+					nearest = findNearestColor(original, palette)
+					nearestCache[original] = nearest
+				}
+				d[i], d[i+1], d[i+2], d[i+3] = nearest.R, nearest.G, nearest.B, nearest.A
+			}
+		}
+	})
+
+	return result
 }
 
+// ditherValue rounds value to a multiple of step, up when its fractional
+// position between the two nearest multiples is above threshold.
 func ditherValue(value uint8, threshold, step float64) uint8 {
-    // divide by step and take the fractional part 
-    normalized := float64(value) / 255.0
-    if normalized > threshold {
-        level := int((normalized * 255.0) / step) + 1
-        return uint8(utils.Clamp(level*int(step), 0, 255))
-    }
-    level := int((normalized * 255.0) / step)
-    return uint8(utils.Clamp(level*int(step), 0, 255))
+	pos := float64(value) / step
+	level := math.Floor(pos)
+	if pos-level > threshold {
+		level++
+	}
+	return uint8(utils.Clamp(int(math.Round(level*step)), 0, 255))
+}
+
+func packRGBA(c color.RGBA) uint32 {
+	return uint32(c.R)<<24 | uint32(c.G)<<16 | uint32(c.B)<<8 | uint32(c.A)
 }
 
 func findNearestColor(c color.RGBA, palette []color.RGBA) color.RGBA {
-    minDist := float64(1<<32 - 1)
-    var nearest color.RGBA
-    
-    for _, p := range palette {
-        dist := colorDistance(c, p)
-        if dist < minDist {
-            minDist = dist
-            nearest = p
-        }
-    }
-    
-    return nearest
+	minDist := float64(1<<32 - 1)
+	var nearest color.RGBA
+
+	for _, p := range palette {
+		dist := colorDistance(c, p)
+		if dist < minDist {
+			minDist = dist
+			nearest = p
+		}
+	}
+
+	return nearest
 }
 
 func colorDistance(c1, c2 color.RGBA) float64 {
-    dr := float64(c1.R) - float64(c2.R)
-    dg := float64(c1.G) - float64(c2.G)
-    db := float64(c1.B) - float64(c2.B)
-    return dr*dr + dg*dg + db*db
+	dr := float64(c1.R) - float64(c2.R)
+	dg := float64(c1.G) - float64(c2.G)
+	db := float64(c1.B) - float64(c2.B)
+	return dr*dr + dg*dg + db*db
 }
 
-
 func YCbCrDithering(src *image.RGBA) *image.RGBA {
-    bounds := src.Bounds()
-    result := image.NewRGBA(bounds)
-    
-    ycbcr := make([][3]float64, bounds.Dx()*bounds.Dy())
-    idx := 0
-    for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-        for x := bounds.Min.X; x < bounds.Max.X; x++ {
-            r, g, b, _ := src.At(x, y).RGBA()
-            r8, g8, b8 := float64(r>>8), float64(g>>8), float64(b>>8)
-        
-            y_val := 0.299*r8 + 0.587*g8 + 0.114*b8
-            cb := 128 - 0.168736*r8 - 0.331264*g8 + 0.5*b8
-            cr := 128 + 0.5*r8 - 0.418688*g8 - 0.081312*b8
-            
-            ycbcr[idx] = [3]float64{y_val, cb, cr}
-            idx++
-        }
-    }
-    
-    thresholdMap := makeThresholdMap(3)
-    step := 255.0 / 2.0
-    
-    idx = 0
-    for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-        for x := bounds.Min.X; x < bounds.Max.X; x++ {
-            threshold := thresholdMap[y%3][x%3]
-            
-        
-            y_val := ditherValue(uint8(ycbcr[idx][0]), threshold, step)
-            cb, cr := ycbcr[idx][1], ycbcr[idx][2]
-            
-        
-            r := float64(y_val) + 1.402*(cr-128)
-            g := float64(y_val) - 0.344136*(cb-128) - 0.714136*(cr-128)
-            b := float64(y_val) + 1.772*(cb-128)
-            
-        
-            result.Set(x, y, color.RGBA{
-                R: uint8(utils.Clamp(int(r), 0, 255)),
-                G: uint8(utils.Clamp(int(g), 0, 255)),
-                B: uint8(utils.Clamp(int(b), 0, 255)),
-                A: 255,
-            })
-            idx++
-        }
-    }
-    
-    return result
-}
\ No newline at end of file
+	bounds := src.Bounds()
+	result := image.NewRGBA(bounds)
+	width := bounds.Dx() * 4
+
+	bayer, _ := BayerMap(3)
+	thresholdMap := bayer.thresholds()
+	step := 255.0 / 2.0
+
+	parallelRows(bounds, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			s := src.Pix[src.PixOffset(bounds.Min.X, y):][:width]
+			d := result.Pix[result.PixOffset(bounds.Min.X, y):][:width]
+			for i, x := 0, bounds.Min.X; i < width; i, x = i+4, x+1 {
+				r8, g8, b8 := float64(s[i]), float64(s[i+1]), float64(s[i+2])
+
+				y_val := 0.299*r8 + 0.587*g8 + 0.114*b8
+				cb := 128 - 0.168736*r8 - 0.331264*g8 + 0.5*b8
+				cr := 128 + 0.5*r8 - 0.418688*g8 - 0.081312*b8
+
+				threshold := thresholdMap[((y%3)+3)%3][((x%3)+3)%3]
+				y_dith := float64(ditherValue(uint8(y_val), threshold, step))
+
+				r := y_dith + 1.402*(cr-128)
+				g := y_dith - 0.344136*(cb-128) - 0.714136*(cr-128)
+				b := y_dith + 1.772*(cb-128)
+
+				d[i] = uint8(utils.Clamp(int(r), 0, 255))
+				d[i+1] = uint8(utils.Clamp(int(g), 0, 255))
+				d[i+2] = uint8(utils.Clamp(int(b), 0, 255))
+				d[i+3] = 255
+			}
+		}
+	})
+
+	return result
+}
@@ -0,0 +1,150 @@
+package filters
+
+import (
+	"image"
+	"math"
+)
+
+// WindowShape is the neighbourhood of a rank filter.
+type WindowShape int
+
+const (
+	WindowSquare WindowShape = iota
+	// WindowCircle contains the pixels within radius of the centre.
+	WindowCircle
+)
+
+var windowShapeNames = []string{"square", "circle"}
+
+func (s WindowShape) String() string {
+	return enumName(windowShapeNames, int(s), "WindowShape")
+}
+
+func ParseWindowShape(s string) (WindowShape, error) {
+	i, err := parseName(windowShapeNames, s, "window shape")
+	return WindowShape(i), err
+}
+
+func init() {
+	for i, r := range []struct {
+		name, label string
+		percentile  float64
+	}{
+		{"median", "Median", 50},
+		{"minimum", "Minimum", 0},
+		{"maximum", "Maximum", 100},
+		{"percentile", "Percentile", -1},
+	} {
+		r := r
+		params := []Param{
+			{Name: "radius", Label: "Radius", Kind: ParamInt, Min: 1, Max: 50, Step: 1, Default: 1},
+			{Name: "shape", Label: "Window", Kind: ParamChoice, Default: WindowSquare.String(), Choices: windowShapeNames},
+		}
+		if r.percentile < 0 {
+			params = append(params, Param{Name: "percentile", Label: "Percentile", Kind: ParamFloat, Min: 0, Max: 100, Step: 1, Default: 25.0})
+		}
+		Register(400+5*i, NewFilter(r.name, r.label, "Rank", append(params, borderParams()...),
+			func(src *image.RGBA, p Params) *image.RGBA {
+				percentile := r.percentile
+				if percentile < 0 {
+					percentile = p.Float("percentile")
+				}
+				shape, _ := ParseWindowShape(p.String("shape"))
+				return RankFilter(src, p.Int("radius"), shape, percentile, borderFromParams(p))
+			}))
+	}
+}
+
+func MedianFilter(src *image.RGBA, radius int, shape WindowShape, border Border) *image.RGBA {
+	return RankFilter(src, radius, shape, 50, border)
+}
+
+// RankFilter replaces every channel by the given percentile of the values
+// in its window: 0 is the minimum, 50 the median and 100 the maximum.
+// It slides a histogram along each row (Huang's algorithm), so the cost
+// per pixel grows with the radius, not with the window area.
+func RankFilter(src *image.RGBA, radius int, shape WindowShape, percentile float64, border Border) *image.RGBA {
+	radius = max(0, radius)
+	extents := windowExtents(radius, shape)
+	var count int
+	for _, e := range extents {
+		count += 2*e + 1
+	}
+	rank := int(math.Round(math.Max(0, math.Min(100, percentile)) / 100 * float64(count-1)))
+
+	in, result, area := border.prepare(src, margins{radius, radius, radius, radius})
+	parallelRows(area, func(y0, y1 int) {
+		var hist [3][256]int
+		for y := y0; y < y1; y++ {
+			hist = [3][256]int{}
+			x := area.Min.X
+			for dy, e := range extents {
+				i := in.PixOffset(x-e, y+dy-radius)
+				for dx := -e; dx <= e; dx++ {
+					hist[0][in.Pix[i]]++
+					hist[1][in.Pix[i+1]]++
+					hist[2][in.Pix[i+2]]++
+					i += 4
+				}
+			}
+
+			var value [3]int
+			var below [3]int
+			d := result.PixOffset(x, y)
+			for ; x < area.Max.X; x++ {
+				if x > area.Min.X {
+					for dy, e := range extents {
+						row := y + dy - radius
+						out := in.PixOffset(x-e-1, row)
+						add := in.PixOffset(x+e, row)
+						for c := 0; c < 3; c++ {
+							v := in.Pix[out+c]
+							hist[c][v]--
+							if int(v) < value[c] {
+								below[c]--
+							}
+							v = in.Pix[add+c]
+							hist[c][v]++
+							if int(v) < value[c] {
+								below[c]++
+							}
+						}
+					}
+				}
+
+				for c := 0; c < 3; c++ {
+					h := &hist[c]
+					v, b := value[c], below[c]
+					for b > rank {
+						v--
+						b -= h[v]
+					}
+					for b+h[v] <= rank {
+						b += h[v]
+						v++
+					}
+					value[c], below[c] = v, b
+					result.Pix[d+c] = uint8(v)
+				}
+				result.Pix[d+3] = in.Pix[in.PixOffset(x, y)+3]
+				d += 4
+			}
+		}
+	})
+	return result
+}
+
+// windowExtents returns, for every row of the window from top to bottom,
+// how far it reaches left and right of the centre column.
+func windowExtents(radius int, shape WindowShape) []int {
+	extents := make([]int, 2*radius+1)
+	for i := range extents {
+		dy := i - radius
+		if shape == WindowCircle {
+			extents[i] = int(math.Sqrt(float64(radius*radius - dy*dy)))
+		} else {
+			extents[i] = radius
+		}
+	}
+	return extents
+}
@@ -0,0 +1,228 @@
+package filters
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"slices"
+)
+
+// StructuringElement is the neighbourhood used by the morphological
+// filters. Mask holds the member pixels row by row, Origin is the member
+// that lines up with the output pixel.
+type StructuringElement struct {
+	Mask   [][]bool
+	Origin image.Point
+}
+
+// ElementShape selects one of the predefined structuring elements.
+type ElementShape int
+
+const (
+	ElementSquare ElementShape = iota
+	ElementCross
+	ElementDisk
+	ElementDiamond
+	ElementLine
+	// ElementCustom uses a user-drawn mask.
+	ElementCustom
+)
+
+var elementShapeNames = []string{"square", "cross", "disk", "diamond", "line", "custom"}
+
+func (s ElementShape) String() string {
+	return enumName(elementShapeNames, int(s), "ElementShape")
+}
+
+func ParseElementShape(s string) (ElementShape, error) {
+	i, err := parseName(elementShapeNames, s, "structuring element")
+	return ElementShape(i), err
+}
+
+// NewElement returns a predefined element of the given radius, centred
+// on its origin. angle is the direction of ElementLine in degrees,
+// counter-clockwise from the x axis. ElementCustom is not predefined and
+// gives a single pixel, see ElementFromMask.
+func NewElement(shape ElementShape, radius int, angle float64) StructuringElement {
+	radius = max(0, radius)
+	size := 2*radius + 1
+	mask := make([][]bool, size)
+	for y := range mask {
+		mask[y] = make([]bool, size)
+	}
+
+	if shape == ElementLine {
+		sin, cos := math.Sincos(angle * math.Pi / 180)
+		for t := -radius; t <= radius; t++ {
+			x := radius + int(math.Round(float64(t)*cos))
+			y := radius - int(math.Round(float64(t)*sin))
+			mask[y][x] = true
+		}
+		return StructuringElement{Mask: mask, Origin: image.Pt(radius, radius)}
+	}
+
+	for y := range mask {
+		for x := range mask[y] {
+			dx, dy := x-radius, y-radius
+			switch shape {
+			case ElementSquare:
+				mask[y][x] = true
+			case ElementCross:
+				mask[y][x] = dx == 0 || dy == 0
+			case ElementDisk:
+				mask[y][x] = dx*dx+dy*dy <= radius*radius
+			case ElementDiamond:
+				mask[y][x] = abs(dx)+abs(dy) <= radius
+			default:
+				mask[y][x] = dx == 0 && dy == 0
+			}
+		}
+	}
+	return StructuringElement{Mask: mask, Origin: image.Pt(radius, radius)}
+}
+
+// ElementFromMask turns a kernel into an element: non-zero weights are
+// members and the kernel anchor becomes the origin.
+func ElementFromMask(k Kernel) StructuringElement {
+	mask := make([][]bool, len(k.Values))
+	for y, row := range k.Values {
+		mask[y] = make([]bool, len(row))
+		for x, v := range row {
+			mask[y][x] = v != 0
+		}
+	}
+	return StructuringElement{Mask: mask, Origin: k.Anchor}
+}
+
+func (e StructuringElement) Width() int {
+	if len(e.Mask) == 0 {
+		return 0
+	}
+	return len(e.Mask[0])
+}
+
+func (e StructuringElement) Height() int {
+	return len(e.Mask)
+}
+
+// Validate checks that the mask is a rectangle with at least one member
+// and that the origin lies inside it.
+func (e StructuringElement) Validate() error {
+	w, h := e.Width(), e.Height()
+	members := 0
+	for i, row := range e.Mask {
+		if len(row) != w {
+			return fmt.Errorf("structuring element row %d has %d values, expected %d", i+1, len(row), w)
+		}
+		for _, m := range row {
+			if m {
+				members++
+			}
+		}
+	}
+	if members == 0 {
+		return errors.New("structuring element is empty")
+	}
+	if !e.Origin.In(image.Rect(0, 0, w, h)) {
+		return fmt.Errorf("structuring element origin %d,%d is outside the %dx%d mask", e.Origin.X, e.Origin.Y, w, h)
+	}
+	return nil
+}
+
+// Reflect mirrors the element through its origin.
+func (e StructuringElement) Reflect() StructuringElement {
+	w, h := e.Width(), e.Height()
+	mask := make([][]bool, h)
+	for y := range mask {
+		mask[y] = make([]bool, w)
+		for x := range mask[y] {
+			mask[y][x] = e.Mask[h-1-y][w-1-x]
+		}
+	}
+	return StructuringElement{Mask: mask, Origin: image.Pt(w-1-e.Origin.X, h-1-e.Origin.Y)}
+}
+
+// String draws the mask with # for members and o for the origin.
+func (e StructuringElement) String() string {
+	var s []byte
+	for y, row := range e.Mask {
+		for x, m := range row {
+			switch {
+			case x == e.Origin.X && y == e.Origin.Y:
+				s = append(s, 'o')
+			case m:
+				s = append(s, '#')
+			default:
+				s = append(s, '.')
+			}
+		}
+		s = append(s, '\n')
+	}
+	return string(s)
+}
+
+// margins returns how far the element reaches past its origin.
+func (e StructuringElement) margins() margins {
+	return margins{e.Origin.X, e.Origin.Y, e.Width() - 1 - e.Origin.X, e.Height() - 1 - e.Origin.Y}
+}
+
+// offsets returns the Pix offsets of the members relative to the origin
+// for an image with the given stride.
+func (e StructuringElement) offsets(stride int) []int {
+	var offs []int
+	for y, row := range e.Mask {
+		for x, m := range row {
+			if m {
+				offs = append(offs, (y-e.Origin.Y)*stride+(x-e.Origin.X)*4)
+			}
+		}
+	}
+	return offs
+}
+
+// elementParams are the parameters of filters that take a structuring
+// element, ElementFromParams reads them back.
+func elementParams() []Param {
+	return []Param{
+		{Name: "shape", Label: "Element", Kind: ParamChoice, Default: ElementSquare.String(), Choices: elementShapeNames},
+		{Name: "radius", Label: "Radius", Kind: ParamInt, Min: 1, Max: 25, Step: 1, Default: 1},
+		{Name: "angle", Label: "Line Angle", Kind: ParamFloat, Min: 0, Max: 180, Step: 1, Default: 0.0},
+		{Name: "mask", Label: "Custom Mask (non-zero = member)", Kind: ParamKernel, Default: NewKernel([][]float64{{0, 1, 0}, {1, 1, 1}, {0, 1, 0}})},
+		{Name: "originx", Label: "Origin X Offset", Kind: ParamInt, Min: -25, Max: 25, Step: 1, Default: 0},
+		{Name: "originy", Label: "Origin Y Offset", Kind: ParamInt, Min: -25, Max: 25, Step: 1, Default: 0},
+	}
+}
+
+// HasElement reports whether f takes a structuring element.
+func HasElement(f Filter) bool {
+	for _, param := range f.Params() {
+		if param.Name == "shape" && slices.Equal(param.Choices, elementShapeNames) {
+			return true
+		}
+	}
+	return false
+}
+
+// ElementFromParams builds the element and moves its origin by originx,
+// originy from the centre of a predefined shape or the anchor of a custom
+// mask.
+func ElementFromParams(p Params) StructuringElement {
+	shape, _ := ParseElementShape(p.String("shape"))
+	var e StructuringElement
+	if shape == ElementCustom {
+		if e = ElementFromMask(p.Kernel("mask")); e.Validate() != nil {
+			e = NewElement(ElementSquare, 0, 0)
+		}
+	} else {
+		e = NewElement(shape, p.Int("radius"), p.Float("angle"))
+	}
+	return e.shiftOrigin(p.Int("originx"), p.Int("originy"))
+}
+
+// shiftOrigin moves the origin by dx, dy, keeping it inside the mask.
+func (e StructuringElement) shiftOrigin(dx, dy int) StructuringElement {
+	e.Origin.X = max(0, min(e.Width()-1, e.Origin.X+dx))
+	e.Origin.Y = max(0, min(e.Height()-1, e.Origin.Y+dy))
+	return e
+}
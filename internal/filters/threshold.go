@@ -0,0 +1,194 @@
+package filters
+
+import (
+	"image"
+	"math"
+)
+
+// ThresholdMethod selects how the threshold level of every pixel is found.
+type ThresholdMethod int
+
+const (
+	// ThresholdFixed uses the same level everywhere.
+	ThresholdFixed ThresholdMethod = iota
+	// ThresholdOtsu picks the global level that best separates the
+	// luminance histogram into two classes.
+	ThresholdOtsu
+	// ThresholdMean and ThresholdGaussian compare every pixel to the mean
+	// of its window, plain or Gaussian weighted, minus an offset.
+	ThresholdMean
+	ThresholdGaussian
+	// ThresholdSauvola uses m * (1 + k * (s/128 - 1)) for the window mean m
+	// and standard deviation s, which suits dark text on uneven light
+	// backgrounds.
+	ThresholdSauvola
+	// ThresholdNiblack uses m - k * s.
+	ThresholdNiblack
+)
+
+var thresholdMethodNames = []string{"fixed", "otsu", "mean", "gaussian", "sauvola", "niblack"}
+
+func (m ThresholdMethod) String() string {
+	return enumName(thresholdMethodNames, int(m), "ThresholdMethod")
+}
+
+func ParseThresholdMethod(s string) (ThresholdMethod, error) {
+	i, err := parseName(thresholdMethodNames, s, "threshold method")
+	return ThresholdMethod(i), err
+}
+
+func init() {
+	Register(600, NewFilter("threshold", "Threshold", "Threshold",
+		append([]Param{
+			{Name: "method", Label: "Method", Kind: ParamChoice, Default: ThresholdFixed.String(), Choices: thresholdMethodNames},
+			{Name: "level", Label: "Level (fixed)", Kind: ParamInt, Min: 0, Max: 255, Step: 1, Default: 128},
+			{Name: "window", Label: "Window Size (adaptive)", Kind: ParamInt, Min: 3, Max: 255, Step: 2, Default: 15},
+			{Name: "k", Label: "k (Sauvola, Niblack)", Kind: ParamFloat, Min: 0, Max: 1, Step: 0.01, Default: 0.2},
+			{Name: "offset", Label: "Offset (mean, Gaussian)", Kind: ParamFloat, Min: -50, Max: 50, Step: 1, Default: 5.0},
+			{Name: "invert", Label: "Invert", Kind: ParamBool, Default: false},
+		}, borderParams()...),
+		func(src *image.RGBA, p Params) *image.RGBA {
+			method, _ := ParseThresholdMethod(p.String("method"))
+			switch method {
+			case ThresholdFixed:
+				return Threshold(src, uint8(p.Int("level")), p.Bool("invert"))
+			case ThresholdOtsu:
+				return Threshold(src, OtsuLevel(src), p.Bool("invert"))
+			}
+			return AdaptiveThreshold(src, method, p.Int("window"), p.Float("k"), p.Float("offset"), p.Bool("invert"), borderFromParams(p))
+		}))
+}
+
+// Threshold turns pixels whose luminance is above level white and the
+// others black, or the other way around with invert. Alpha is kept.
+func Threshold(src *image.RGBA, level uint8, invert bool) *image.RGBA {
+	bounds := src.Bounds()
+	result := image.NewRGBA(bounds)
+	luma := lumaPlane(src)
+	parallelRows(bounds, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			l := luma[(y-bounds.Min.Y)*bounds.Dx():][:bounds.Dx()]
+			s := src.Pix[src.PixOffset(bounds.Min.X, y):][:bounds.Dx()*4]
+			d := result.Pix[result.PixOffset(bounds.Min.X, y):][:bounds.Dx()*4]
+			for x, v := range l {
+				setBinary(d[x*4:x*4+4], v > level != invert, s[x*4+3])
+			}
+		}
+	})
+	return result
+}
+
+// OtsuLevel returns the luminance level that maximizes the between-class
+// variance of the pixels at or below it and those above it.
+func OtsuLevel(src *image.RGBA) uint8 {
+	var hist [256]int
+	for _, v := range lumaPlane(src) {
+		hist[v]++
+	}
+	var total, sum float64
+	for v, n := range hist {
+		total += float64(n)
+		sum += float64(v * n)
+	}
+
+	var best uint8
+	var bestVar, countBelow, sumBelow float64
+	for t, n := range hist {
+		countBelow += float64(n)
+		sumBelow += float64(t * n)
+		countAbove := total - countBelow
+		if countBelow == 0 || countAbove == 0 {
+			continue
+		}
+		diff := sumBelow/countBelow - (sum-sumBelow)/countAbove
+		if v := countBelow * countAbove * diff * diff; v > bestVar {
+			bestVar, best = v, uint8(t)
+		}
+	}
+	return best
+}
+
+// AdaptiveThreshold compares the luminance of every pixel to a level
+// computed from the window x window pixels around it with one of the
+// local methods. k weighs the standard deviation for ThresholdSauvola and
+// ThresholdNiblack, offset is subtracted from the mean for ThresholdMean
+// and ThresholdGaussian.
+func AdaptiveThreshold(src *image.RGBA, method ThresholdMethod, window int, k, offset float64, invert bool, border Border) *image.RGBA {
+	r := max(1, window/2)
+	in, dst, area := border.prepare(src, margins{r, r, r, r})
+	luma := lumaPlane(in)
+	ib := in.Bounds()
+	w := ib.Dx()
+
+	var blurred []uint8
+	var sum, sq []uint64
+	if method == ThresholdGaussian {
+		// The sigma OpenCV derives from the window size.
+		sigma := 0.3*(float64(r)-1) + 0.8
+		blurred = lumaPlane(GaussianBlur(in, sigma, r, Border{Mode: BorderKeep}))
+	} else {
+		sum, sq = integralImages(luma, w, ib.Dy())
+	}
+
+	n := float64((2*r + 1) * (2*r + 1))
+	parallelRows(area, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			d := dst.PixOffset(area.Min.X, y)
+			for x := area.Min.X; x < area.Max.X; x++ {
+				i := (y-ib.Min.Y)*w + x - ib.Min.X
+				var level float64
+				if method == ThresholdGaussian {
+					level = float64(blurred[i]) - offset
+				} else {
+					// Window corners in the integral images, which have
+					// one more row and column than the image.
+					x0, x1 := x-r-ib.Min.X, x+r+1-ib.Min.X
+					y0, y1 := y-r-ib.Min.Y, y+r+1-ib.Min.Y
+					at := func(s []uint64) float64 {
+						return float64(s[y1*(w+1)+x1] + s[y0*(w+1)+x0] - s[y0*(w+1)+x1] - s[y1*(w+1)+x0])
+					}
+					mean := at(sum) / n
+					std := math.Sqrt(math.Max(0, at(sq)/n-mean*mean))
+					switch method {
+					case ThresholdSauvola:
+						level = mean * (1 + k*(std/128-1))
+					case ThresholdNiblack:
+						level = mean - k*std
+					default:
+						level = mean - offset
+					}
+				}
+				setBinary(dst.Pix[d:d+4], float64(luma[i]) > level != invert, in.Pix[in.PixOffset(x, y)+3])
+				d += 4
+			}
+		}
+	})
+	return dst
+}
+
+// integralImages returns the summed-area tables of v and of its squares,
+// with a leading row and column of zeros.
+func integralImages(v []uint8, w, h int) (sum, sq []uint64) {
+	sum = make([]uint64, (w+1)*(h+1))
+	sq = make([]uint64, (w+1)*(h+1))
+	for y := 0; y < h; y++ {
+		var rowSum, rowSq uint64
+		for x := 0; x < w; x++ {
+			p := uint64(v[y*w+x])
+			rowSum += p
+			rowSq += p * p
+			i := (y+1)*(w+1) + x + 1
+			sum[i] = sum[i-w-1] + rowSum
+			sq[i] = sq[i-w-1] + rowSq
+		}
+	}
+	return sum, sq
+}
+
+func setBinary(d []uint8, white bool, alpha uint8) {
+	var c uint8
+	if white {
+		c = 255
+	}
+	d[0], d[1], d[2], d[3] = c, c, c, alpha
+}
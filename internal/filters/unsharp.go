@@ -0,0 +1,64 @@
+package filters
+
+import (
+	"image"
+	"math"
+)
+
+func init() {
+	Register(225, NewFilter("unsharp", "Unsharp Mask", "Convolution",
+		append([]Param{
+			{Name: "amount", Label: "Amount", Kind: ParamFloat, Min: 0, Max: 5, Step: 0.1, Default: 1.0},
+			{Name: "radius", Label: "Radius (sigma)", Kind: ParamFloat, Min: 0.1, Max: 20, Step: 0.1, Default: 2.0},
+			{Name: "threshold", Label: "Threshold", Kind: ParamInt, Min: 0, Max: 255, Step: 1, Default: 0},
+			{Name: "luminance", Label: "Luminance only", Kind: ParamBool, Default: false},
+		}, borderParams()...),
+		func(src *image.RGBA, p Params) *image.RGBA {
+			return UnsharpMask(src, p.Float("amount"), p.Float("radius"), p.Int("threshold"), p.Bool("luminance"), borderFromParams(p))
+		}))
+}
+
+// UnsharpMask sharpens by adding back amount times the difference between
+// src and a Gaussian blur of sigma radius. Differences below threshold are
+// ignored, which keeps noise in flat areas from being amplified. With
+// luminance set, only the luminance difference is added, equally to all
+// channels, so edges do not get coloured fringes.
+func UnsharpMask(src *image.RGBA, amount, radius float64, threshold int, luminance bool, border Border) *image.RGBA {
+	blurred := GaussianBlur(src, radius, 0, border)
+	bounds := blurred.Bounds()
+	result := image.NewRGBA(bounds)
+
+	parallelRows(bounds, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			s := src.Pix[src.PixOffset(bounds.Min.X, y):][:bounds.Dx()*4]
+			b := blurred.Pix[blurred.PixOffset(bounds.Min.X, y):][:bounds.Dx()*4]
+			d := result.Pix[result.PixOffset(bounds.Min.X, y):][:bounds.Dx()*4]
+			for i := 0; i < len(d); i += 4 {
+				if luminance {
+					diff := luma(s[i:i+3]) - luma(b[i:i+3])
+					if math.Abs(diff) < float64(threshold) {
+						diff = 0
+					}
+					for c := 0; c < 3; c++ {
+						d[i+c] = roundChannel(float64(s[i+c]) + amount*diff)
+					}
+				} else {
+					for c := 0; c < 3; c++ {
+						diff := int(s[i+c]) - int(b[i+c])
+						if abs(diff) < threshold {
+							diff = 0
+						}
+						d[i+c] = roundChannel(float64(s[i+c]) + amount*float64(diff))
+					}
+				}
+				d[i+3] = s[i+3]
+			}
+		}
+	})
+	return result
+}
+
+// luma returns the Rec. 601 luminance of an RGB triple.
+func luma(rgb []uint8) float64 {
+	return 0.299*float64(rgb[0]) + 0.587*float64(rgb[1]) + 0.114*float64(rgb[2])
+}
@@ -0,0 +1,56 @@
+package gui
+
+import (
+	"fmt"
+
+	"image-filter-editor/internal/history"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+)
+
+// HistoryPanel lists the undo history and jumps to an entry when clicked.
+type HistoryPanel struct {
+	container *fyne.Container
+	history   *history.History
+	onJump    func(i int)
+}
+
+func NewHistoryPanel(h *history.History) *HistoryPanel {
+	p := &HistoryPanel{
+		container: container.NewVBox(),
+		history:   h,
+	}
+	p.Refresh()
+	return p
+}
+
+func (p *HistoryPanel) Refresh() {
+	p.container.RemoveAll()
+	p.container.Add(widget.NewLabelWithStyle("History", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}))
+
+	for i, entry := range p.history.Entries() {
+		i := i
+		btn := widget.NewButton(fmt.Sprintf("%d. %s", i+1, entry.Label), func() {
+			if p.onJump != nil {
+				p.onJump(i)
+			}
+		})
+		switch {
+		case i == p.history.Current():
+			btn.Importance = widget.HighImportance
+		case i > p.history.Current():
+			btn.Importance = widget.LowImportance
+		}
+		p.container.Add(btn)
+	}
+}
+
+func (p *HistoryPanel) GetContainer() fyne.CanvasObject {
+	return p.container
+}
+
+func (p *HistoryPanel) SetOnJump(callback func(i int)) {
+	p.onJump = callback
+}
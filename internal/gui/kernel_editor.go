@@ -0,0 +1,367 @@
+package gui
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"strconv"
+	"strings"
+
+	"image-filter-editor/internal/filters"
+	"image-filter-editor/internal/kernels"
+	"image-filter-editor/internal/pipeline"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+const maxKernelSize = 15
+
+// KernelEditor edits the kernel of a Custom Kernel step in its own window,
+// so the main window keeps showing the live result. Kernels can be stored
+// under a name in the user's library.
+type KernelEditor struct {
+	window  fyne.Window
+	step    *pipeline.Step
+	library *kernels.Library
+	dirty   bool
+
+	cells      [][]*widget.Entry
+	grid       *fyne.Container
+	text       *widget.Entry
+	width      *widget.Select
+	height     *widget.Select
+	anchorX    *widget.Select
+	anchorY    *widget.Select
+	autoDiv    *widget.Check
+	divisor    *widget.Entry
+	offset     *widget.Entry
+	errLabel   *widget.Label
+	name       *widget.Entry
+	libraryBox *fyne.Container
+
+	onUpdate  func()
+	onCommit  func(label string)
+	onLibrary func()
+}
+
+func NewKernelEditor(app fyne.App, step *pipeline.Step, library *kernels.Library) *KernelEditor {
+	e := &KernelEditor{
+		window:     app.NewWindow("Kernel Editor"),
+		step:       step,
+		library:    library,
+		grid:       container.NewVBox(),
+		text:       widget.NewMultiLineEntry(),
+		errLabel:   widget.NewLabel(""),
+		name:       widget.NewEntry(),
+		libraryBox: container.NewVBox(),
+	}
+
+	sizes := make([]string, maxKernelSize)
+	for i := range sizes {
+		sizes[i] = strconv.Itoa(i + 1)
+	}
+	e.width = widget.NewSelect(sizes, func(string) { e.resize() })
+	e.height = widget.NewSelect(sizes, func(string) { e.resize() })
+	e.anchorX = widget.NewSelect(nil, func(string) { e.update() })
+	e.anchorY = widget.NewSelect(nil, func(string) { e.update() })
+	e.divisor = widget.NewEntry()
+	e.divisor.OnChanged = func(string) { e.update() }
+	e.autoDiv = widget.NewCheck("Auto (sum of weights)", func(on bool) {
+		if on {
+			e.divisor.Disable()
+		} else {
+			e.divisor.Enable()
+		}
+		e.update()
+	})
+	e.offset = widget.NewEntry()
+	e.offset.OnChanged = func(string) { e.update() }
+
+	e.text.SetPlaceHolder("0 -1 0\n-1 5 -1\n0 -1 0")
+	applyText := widget.NewButton("Use Text", func() {
+		m, err := parseMatrix(e.text.Text)
+		if err != nil {
+			e.errLabel.SetText(err.Error())
+			return
+		}
+		if len(m) > maxKernelSize || len(m[0]) > maxKernelSize {
+			e.errLabel.SetText(fmt.Sprintf("kernels are limited to %dx%d", maxKernelSize, maxKernelSize))
+			return
+		}
+		k, _ := e.read()
+		nk := filters.NewKernel(m)
+		nk.Divisor, nk.Offset = k.Divisor, k.Offset
+		e.show(nk)
+		e.update()
+	})
+
+	e.name.SetPlaceHolder("Kernel name")
+	saveBtn := widget.NewButton("Save to Library", e.saveToLibrary)
+
+	e.show(step.Params.Kernel("kernel"))
+	e.refreshLibrary()
+
+	settings := widget.NewForm(
+		widget.NewFormItem("Width", e.width),
+		widget.NewFormItem("Height", e.height),
+		widget.NewFormItem("Anchor X", e.anchorX),
+		widget.NewFormItem("Anchor Y", e.anchorY),
+		widget.NewFormItem("Divisor", container.NewVBox(e.autoDiv, e.divisor)),
+		widget.NewFormItem("Offset", e.offset),
+	)
+	tabs := container.NewAppTabs(
+		container.NewTabItem("Grid", container.NewScroll(e.grid)),
+		container.NewTabItem("Text", container.NewBorder(nil, applyText, nil, nil, e.text)),
+	)
+	libraryPanel := container.NewVBox(
+		widget.NewLabelWithStyle("Library", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
+		container.NewBorder(nil, nil, nil, saveBtn, e.name),
+		e.libraryBox,
+	)
+
+	e.window.SetContent(container.NewBorder(
+		nil, e.errLabel, settings, container.NewVScroll(libraryPanel), tabs,
+	))
+	e.window.Resize(fyne.NewSize(900, 450))
+	e.window.SetOnClosed(func() {
+		if e.dirty {
+			e.commit("Edit " + e.step.Label())
+		}
+	})
+	return e
+}
+
+// show fills all widgets from k without triggering updates.
+func (e *KernelEditor) show(k filters.Kernel) {
+	w, h := k.Width(), k.Height()
+	e.cells = make([][]*widget.Entry, h)
+	for y := range e.cells {
+		e.cells[y] = make([]*widget.Entry, w)
+		for x := range e.cells[y] {
+			cell := widget.NewEntry()
+			cell.SetText(strconv.FormatFloat(k.Values[y][x], 'g', 6, 64))
+			cell.OnChanged = func(string) { e.update() }
+			e.cells[y][x] = cell
+		}
+	}
+	e.rebuildGrid()
+
+	e.setSelect(e.width, strconv.Itoa(w), nil)
+	e.setSelect(e.height, strconv.Itoa(h), nil)
+	e.setSelect(e.anchorX, strconv.Itoa(k.Anchor.X), indices(w))
+	e.setSelect(e.anchorY, strconv.Itoa(k.Anchor.Y), indices(h))
+
+	e.autoDiv.Checked = k.Divisor == 0
+	e.autoDiv.Refresh()
+	e.setEntry(e.divisor, strconv.FormatFloat(k.EffectiveDivisor(), 'g', 6, 64))
+	if k.Divisor == 0 {
+		e.divisor.Disable()
+	} else {
+		e.divisor.Enable()
+	}
+	e.setEntry(e.offset, strconv.FormatFloat(k.Offset, 'g', 6, 64))
+	e.text.SetText(formatMatrix(k.Values))
+}
+
+func (e *KernelEditor) rebuildGrid() {
+	e.grid.RemoveAll()
+	if len(e.cells) == 0 {
+		return
+	}
+	cols := container.NewGridWithColumns(len(e.cells[0]))
+	for _, row := range e.cells {
+		for _, cell := range row {
+			cols.Add(cell)
+		}
+	}
+	e.grid.Add(cols)
+}
+
+// resize changes the grid size, keeping the values that still fit and
+// moving the anchor to the new centre.
+func (e *KernelEditor) resize() {
+	w, _ := strconv.Atoi(e.width.Selected)
+	h, _ := strconv.Atoi(e.height.Selected)
+	if w == 0 || h == 0 || (len(e.cells) == h && len(e.cells[0]) == w) {
+		return
+	}
+	values := make([][]float64, h)
+	for y := range values {
+		values[y] = make([]float64, w)
+		for x := range values[y] {
+			if y < len(e.cells) && x < len(e.cells[y]) {
+				values[y][x], _ = strconv.ParseFloat(e.cells[y][x].Text, 64)
+			}
+		}
+	}
+	k, _ := e.read()
+	nk := filters.NewKernel(values)
+	nk.Divisor, nk.Offset = k.Divisor, k.Offset
+	e.show(nk)
+	e.update()
+}
+
+// read builds a kernel from the widgets.
+func (e *KernelEditor) read() (filters.Kernel, error) {
+	values := make([][]float64, len(e.cells))
+	for y, row := range e.cells {
+		values[y] = make([]float64, len(row))
+		for x, cell := range row {
+			v, err := strconv.ParseFloat(strings.TrimSpace(cell.Text), 64)
+			if err != nil {
+				return filters.Kernel{}, fmt.Errorf("invalid number %q in row %d, column %d", cell.Text, y+1, x+1)
+			}
+			values[y][x] = v
+		}
+	}
+	k := filters.NewKernel(values)
+	ax, _ := strconv.Atoi(e.anchorX.Selected)
+	ay, _ := strconv.Atoi(e.anchorY.Selected)
+	k.Anchor = image.Pt(ax, ay)
+
+	k.Divisor = 0
+	if !e.autoDiv.Checked {
+		d, err := strconv.ParseFloat(strings.TrimSpace(e.divisor.Text), 64)
+		if err != nil || d == 0 {
+			return filters.Kernel{}, errors.New("divisor must be a non-zero number")
+		}
+		k.Divisor = d
+	}
+	offset, err := strconv.ParseFloat(strings.TrimSpace(e.offset.Text), 64)
+	if err != nil {
+		return filters.Kernel{}, errors.New("offset must be a number")
+	}
+	k.Offset = offset
+	return k, k.Validate()
+}
+
+// update applies the edited kernel to the step for a live preview.
+func (e *KernelEditor) update() {
+	if e.cells == nil {
+		return
+	}
+	k, err := e.read()
+	if err != nil {
+		e.errLabel.SetText(err.Error())
+		return
+	}
+	e.errLabel.SetText("")
+	e.step.Params["kernel"] = k
+	e.dirty = true
+	if e.onUpdate != nil {
+		e.onUpdate()
+	}
+}
+
+func (e *KernelEditor) saveToLibrary() {
+	if e.library == nil {
+		e.errLabel.SetText("the kernel library could not be loaded")
+		return
+	}
+	k, err := e.read()
+	if err == nil {
+		err = e.library.Put(strings.TrimSpace(e.name.Text), k)
+	}
+	if err == nil {
+		err = e.library.Save()
+	}
+	if err != nil {
+		e.errLabel.SetText(err.Error())
+		return
+	}
+	e.errLabel.SetText("")
+	e.libraryChanged()
+}
+
+func (e *KernelEditor) refreshLibrary() {
+	e.libraryBox.RemoveAll()
+	if e.library == nil {
+		return
+	}
+	for _, entry := range e.library.Entries() {
+		entry := entry
+		load := widget.NewButton(entry.Name, func() {
+			e.name.SetText(entry.Name)
+			e.show(entry.Kernel)
+			e.update()
+		})
+		remove := widget.NewButtonWithIcon("", theme.DeleteIcon(), func() {
+			e.library.Remove(entry.Name)
+			if err := e.library.Save(); err != nil {
+				e.errLabel.SetText(err.Error())
+			}
+			e.libraryChanged()
+		})
+		e.libraryBox.Add(container.NewBorder(nil, nil, nil, remove, load))
+	}
+}
+
+func (e *KernelEditor) libraryChanged() {
+	e.refreshLibrary()
+	if e.onLibrary != nil {
+		e.onLibrary()
+	}
+}
+
+// setSelect changes a select without running its callback.
+func (e *KernelEditor) setSelect(s *widget.Select, value string, options []string) {
+	changed := s.OnChanged
+	s.OnChanged = nil
+	if options != nil {
+		s.Options = options
+	}
+	s.SetSelected(value)
+	s.OnChanged = changed
+}
+
+// setEntry changes an entry without running its callback.
+func (e *KernelEditor) setEntry(entry *widget.Entry, text string) {
+	changed := entry.OnChanged
+	entry.OnChanged = nil
+	entry.SetText(text)
+	entry.OnChanged = changed
+}
+
+func (e *KernelEditor) commit(label string) {
+	e.dirty = false
+	if e.onCommit != nil {
+		e.onCommit(label)
+	}
+}
+
+func (e *KernelEditor) Show() {
+	e.window.Show()
+}
+
+// Close closes the window without recording pending edits, e.g. because
+// undo replaced the step it edits.
+func (e *KernelEditor) Close() {
+	e.dirty = false
+	e.window.Close()
+}
+
+func (e *KernelEditor) SetOnUpdate(callback func()) {
+	e.onUpdate = callback
+}
+
+// SetOnCommit is called when the window is closed after edits, so that
+// they end up in the undo history as one entry.
+func (e *KernelEditor) SetOnCommit(callback func(label string)) {
+	e.onCommit = callback
+}
+
+// SetOnLibraryChange is called after kernels were saved or deleted.
+func (e *KernelEditor) SetOnLibraryChange(callback func()) {
+	e.onLibrary = callback
+}
+
+func indices(n int) []string {
+	s := make([]string, n)
+	for i := range s {
+		s[i] = strconv.Itoa(i)
+	}
+	return s
+}
@@ -2,123 +2,291 @@ package gui
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
+
+	"image-filter-editor/internal/filters"
+	"image-filter-editor/internal/pipeline"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/widget"
 )
 
+// FilterOverlay shows the parameters of the selected stack step. Editing
+// a value changes the step in place, it never applies another pass.
 type FilterOverlay struct {
-    container *fyne.Container
-    sliders   map[string]*widget.Slider
-    values    map[string]float64
-    labels    map[string]*widget.Label
-    onUpdate  func(string, float64)
+	container *fyne.Container
+	step      *pipeline.Step
+	element   *widget.Label
+	onUpdate  func()
+	onCommit  func(label string)
 }
 
 func NewFilterOverlay() *FilterOverlay {
-    f := &FilterOverlay{
-        sliders: make(map[string]*widget.Slider),
-        values:  make(map[string]float64),
-        labels:  make(map[string]*widget.Label),
-    }
-    
-    
-    sliderConfigs := map[string]struct {
-        min, max, value, step float64
-        label string
-    }{
-        "brightness": {-100, 100, 0, 1, "Brightness"},
-        "contrast":   {0, 3, 1, 0.1, "Contrast"},
-        "gamma":      {0.1, 3, 1, 0.1, "Gamma"},
-        "saturation": {0, 2, 1, 0.1, "Saturation"},
-        "dither_levels": {2, 8, 2, 1, "Dither Levels"},
-        "dither_size":   {2, 8, 2, 2, "Dither Map Size"},
-        "num_colors":    {2, 256, 16, 1, "Number of Colors"},
-    }
-
-    var elements []fyne.CanvasObject
-    
-    
-    for id, config := range sliderConfigs {
-        nameLabel := widget.NewLabel(config.label)
-        valueLabel := widget.NewLabel(formatValue(config.value))
-        f.labels[id] = valueLabel
-        
-        slider := widget.NewSlider(config.min, config.max)
-        slider.Step = config.step
-        slider.Value = config.value
-        f.values[id] = config.value
-        f.sliders[id] = slider
-        
-        id := id
-        slider.OnChanged = func(v float64) {
-            f.values[id] = v
-            f.labels[id].SetText(formatValue(v))
-            if f.onUpdate != nil {
-                f.onUpdate(id, v)
-            }
-        }
-        
-
-        sliderContainer := container.NewBorder(nil, nil, nil, valueLabel, slider)
-        elements = append(elements, nameLabel, sliderContainer)
-    }
-
-    resetBtn := widget.NewButton("Reset All", func() {
-        for id, config := range sliderConfigs {
-            f.sliders[id].Value = config.value
-            f.values[id] = config.value
-            f.labels[id].SetText(formatValue(config.value))
-            f.sliders[id].Refresh()
-            if f.onUpdate != nil {
-                f.onUpdate(id, config.value)
-            }
-        }
-    })
-
-    grayscaleBtn := widget.NewButton("Convert to Grayscale", func() {
-        if f.onUpdate != nil {
-            f.onUpdate("grayscale", 0)
-        }
-    })
-
-    elements = append(elements, resetBtn)
-
-    ditherBtn := widget.NewButton("Apply Dithering", func() {
-        if f.onUpdate != nil {
-            f.onUpdate("dither", f.values["dither_levels"])
-        }
-    })
-
-    quantizeBtn := widget.NewButton("Quantize Colors", func() {
-        if f.onUpdate != nil {
-            f.onUpdate("quantize", f.values["num_colors"])
-        }
-    })
-
-    elements = append(elements, grayscaleBtn, ditherBtn, quantizeBtn)
-
-    f.container = container.NewVBox(elements...)
-    
-    return f
+	f := &FilterOverlay{
+		container: container.NewVBox(),
+	}
+	f.ShowStep(nil)
+	return f
+}
+
+func (f *FilterOverlay) ShowStep(step *pipeline.Step) {
+	f.step = step
+	f.element = nil
+	f.container.RemoveAll()
+
+	if step == nil {
+		f.container.Add(widget.NewLabel("Select a step to edit its parameters"))
+		return
+	}
+	flt, ok := filters.Lookup(step.Filter)
+	if !ok {
+		return
+	}
+
+	f.container.Add(widget.NewLabelWithStyle(flt.Label(), fyne.TextAlignLeading, fyne.TextStyle{Bold: true}))
+	if len(flt.Params()) == 0 {
+		f.container.Add(widget.NewLabel("No parameters"))
+		return
+	}
+	for _, param := range flt.Params() {
+		for _, obj := range f.paramWidget(param) {
+			f.container.Add(obj)
+		}
+	}
+	if filters.HasElement(flt) {
+		// Preview of the structuring element, updated as its
+		// parameters change.
+		f.element = widget.NewLabelWithStyle("", fyne.TextAlignLeading, fyne.TextStyle{Monospace: true})
+		f.updateElement()
+		f.container.Add(widget.NewLabel("Element Preview"))
+		f.container.Add(f.element)
+	}
+
+	f.container.Add(widget.NewButton("Reset Parameters", func() {
+		step.Params = filters.Defaults(flt)
+		f.ShowStep(step)
+		f.changed()
+		f.commit("Reset " + flt.Label())
+	}))
+}
+
+func (f *FilterOverlay) paramWidget(param filters.Param) []fyne.CanvasObject {
+	values := f.step.Params
+	name := param.Name
+
+	switch param.Kind {
+	case filters.ParamBool:
+		check := widget.NewCheck(param.Label, func(v bool) {
+			f.set(name, v)
+			f.commit("Edit " + f.step.Label())
+		})
+		check.Checked = values.Bool(name)
+		return []fyne.CanvasObject{check}
+
+	case filters.ParamChoice:
+		sel := widget.NewSelect(param.Choices, func(v string) {
+			f.set(name, v)
+			f.commit("Edit " + f.step.Label())
+		})
+		sel.Selected = values.String(name)
+		return []fyne.CanvasObject{widget.NewLabel(param.Label), sel}
+
+	case filters.ParamPoints, filters.ParamKernel:
+		return f.textWidget(param)
+
+	case filters.ParamColor:
+		entry := widget.NewEntry()
+		entry.SetText(filters.FormatColor(values.Color(name)))
+		entry.SetPlaceHolder("#rrggbb")
+		entry.Validator = func(s string) error {
+			_, err := filters.ParseColor(s)
+			return err
+		}
+		entry.OnSubmitted = func(s string) {
+			if c, err := filters.ParseColor(s); err == nil {
+				f.set(name, c)
+				f.commit("Edit " + f.step.Label())
+			}
+		}
+		return []fyne.CanvasObject{widget.NewLabel(param.Label), entry}
+	}
+
+	valueLabel := widget.NewLabel(formatValue(values.Float(name)))
+	slider := widget.NewSlider(param.Min, param.Max)
+	slider.Step = param.Step
+	slider.Value = values.Float(name)
+	slider.OnChanged = func(v float64) {
+		valueLabel.SetText(formatValue(v))
+		f.set(name, v)
+	}
+	slider.OnChangeEnded = func(float64) {
+		f.commit("Edit " + f.step.Label())
+	}
+
+	sliderContainer := container.NewBorder(nil, nil, nil, valueLabel, slider)
+	return []fyne.CanvasObject{widget.NewLabel(param.Label), sliderContainer}
+}
+
+// textWidget edits structured parameters as text: control points as
+// "x,y" pairs, kernels as one row per line.
+func (f *FilterOverlay) textWidget(param filters.Param) []fyne.CanvasObject {
+	entry := widget.NewMultiLineEntry()
+	errLabel := widget.NewLabel("")
+	if param.Kind == filters.ParamPoints {
+		entry.SetText(formatPoints(f.step.Params.Points(param.Name)))
+		entry.SetPlaceHolder("0,0 128,160 255,255")
+	} else {
+		entry.SetText(formatMatrix(f.step.Params.Kernel(param.Name).Values))
+		entry.SetPlaceHolder("0 -1 0\n-1 5 -1\n0 -1 0")
+	}
+
+	apply := widget.NewButton("Apply", func() {
+		var v any
+		var err error
+		if param.Kind == filters.ParamPoints {
+			v, err = parsePoints(entry.Text)
+		} else {
+			v, err = parseKernel(entry.Text, f.step.Params.Kernel(param.Name))
+		}
+		if err != nil {
+			errLabel.SetText(err.Error())
+			return
+		}
+		errLabel.SetText("")
+		f.set(param.Name, v)
+		f.commit("Edit " + f.step.Label())
+	})
+
+	return []fyne.CanvasObject{widget.NewLabel(param.Label), entry, apply, errLabel}
+}
+
+func (f *FilterOverlay) set(param string, value any) {
+	if f.step == nil {
+		return
+	}
+	f.step.Params[param] = value
+	f.updateElement()
+	f.changed()
+}
+
+func (f *FilterOverlay) updateElement() {
+	if f.element != nil {
+		f.element.SetText(strings.TrimSuffix(filters.ElementFromParams(f.step.Params).String(), "\n"))
+	}
+}
+
+func (f *FilterOverlay) changed() {
+	if f.onUpdate != nil {
+		f.onUpdate()
+	}
+}
+
+func (f *FilterOverlay) commit(label string) {
+	if f.onCommit != nil {
+		f.onCommit(label)
+	}
 }
 
 func (f *FilterOverlay) GetContainer() fyne.CanvasObject {
-    return f.container
+	return f.container
 }
 
-func (f *FilterOverlay) SetOnUpdate(callback func(param string, value float64)) {
-    f.onUpdate = callback
+func (f *FilterOverlay) SetOnUpdate(callback func()) {
+	f.onUpdate = callback
 }
 
-func (f *FilterOverlay) GetValue(param string) float64 {
-    return f.values[param]
+// SetOnCommit is called once an edit is finished, e.g. when a slider is
+// released, so that it can be recorded in the undo history.
+func (f *FilterOverlay) SetOnCommit(callback func(label string)) {
+	f.onCommit = callback
 }
 
 func formatValue(value float64) string {
-    if value == float64(int(value)) {
-        return fmt.Sprintf("%.0f", value)
-    }
-    return fmt.Sprintf("%.1f", value)
-}
\ No newline at end of file
+	if value == float64(int(value)) {
+		return fmt.Sprintf("%.0f", value)
+	}
+	return fmt.Sprintf("%.1f", value)
+}
+
+func formatPoints(points []filters.Point) string {
+	parts := make([]string, len(points))
+	for i, p := range points {
+		parts[i] = fmt.Sprintf("%g,%g", p.X, p.Y)
+	}
+	return strings.Join(parts, " ")
+}
+
+func parsePoints(text string) ([]filters.Point, error) {
+	var points []filters.Point
+	for _, pair := range strings.Fields(text) {
+		xs, ys, ok := strings.Cut(pair, ",")
+		x, errX := strconv.ParseFloat(xs, 64)
+		y, errY := strconv.ParseFloat(ys, 64)
+		if !ok || errX != nil || errY != nil {
+			return nil, fmt.Errorf("invalid point %q, expected x,y", pair)
+		}
+		points = append(points, filters.Point{X: x, Y: y})
+	}
+	if len(points) < 2 {
+		return nil, fmt.Errorf("at least two points are required")
+	}
+	return points, nil
+}
+
+func formatMatrix(m [][]float64) string {
+	rows := make([]string, len(m))
+	for i, row := range m {
+		vals := make([]string, len(row))
+		for j, v := range row {
+			vals[j] = strconv.FormatFloat(v, 'g', 6, 64)
+		}
+		rows[i] = strings.Join(vals, " ")
+	}
+	return strings.Join(rows, "\n")
+}
+
+func parseMatrix(text string) ([][]float64, error) {
+	var m [][]float64
+	for _, line := range strings.Split(text, "\n") {
+		fields := strings.FieldsFunc(line, func(r rune) bool {
+			return r == ' ' || r == ',' || r == '\t'
+		})
+		if len(fields) == 0 {
+			continue
+		}
+		row := make([]float64, len(fields))
+		for i, field := range fields {
+			v, err := strconv.ParseFloat(field, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q", field)
+			}
+			row[i] = v
+		}
+		if len(m) > 0 && len(row) != len(m[0]) {
+			return nil, fmt.Errorf("all rows must have %d values", len(m[0]))
+		}
+		m = append(m, row)
+	}
+	if len(m) == 0 {
+		return nil, fmt.Errorf("kernel must have at least one value")
+	}
+	return m, nil
+}
+
+// parseKernel replaces the weights of cur. The divisor and offset are
+// kept, and the anchor keeps its place relative to the centre when the
+// size changes, as far as the new size allows.
+func parseKernel(text string, cur filters.Kernel) (filters.Kernel, error) {
+	m, err := parseMatrix(text)
+	if err != nil {
+		return filters.Kernel{}, err
+	}
+	k := filters.NewKernel(m)
+	k.Divisor, k.Offset = cur.Divisor, cur.Offset
+	shift := cur.Anchor.Sub(filters.NewKernel(cur.Values).Anchor)
+	k.Anchor.X = max(0, min(k.Width()-1, k.Anchor.X+shift.X))
+	k.Anchor.Y = max(0, min(k.Height()-1, k.Anchor.Y+shift.Y))
+	return k, nil
+}
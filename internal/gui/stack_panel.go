@@ -0,0 +1,163 @@
+package gui
+
+import (
+	"fmt"
+
+	"image-filter-editor/internal/pipeline"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+// StackPanel lists the steps of a filter stack and lets the user select,
+// toggle, reorder and remove them.
+type StackPanel struct {
+	container *fyne.Container
+	stack     *pipeline.Stack
+	selected  int
+	onChange  func(label string)
+	onSelect  func(step *pipeline.Step)
+	onRemove  func(step *pipeline.Step)
+}
+
+func NewStackPanel(stack *pipeline.Stack) *StackPanel {
+	p := &StackPanel{
+		container: container.NewVBox(),
+		stack:     stack,
+		selected:  -1,
+	}
+	p.Refresh()
+	return p
+}
+
+func (p *StackPanel) Refresh() {
+	p.container.RemoveAll()
+	p.container.Add(widget.NewLabelWithStyle("Filter Stack", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}))
+
+	if p.stack.Len() == 0 {
+		p.container.Add(widget.NewLabel("Empty - use the toolbar to add filters"))
+		return
+	}
+
+	for i, step := range p.stack.Steps {
+		i, step := i, step
+
+		enabled := widget.NewCheck("", func(on bool) {
+			if step.Enabled != on {
+				step.Enabled = on
+				if on {
+					p.changed("Enable " + step.Label())
+				} else {
+					p.changed("Disable " + step.Label())
+				}
+			}
+		})
+		enabled.Checked = step.Enabled
+
+		name := widget.NewButton(fmt.Sprintf("%d. %s", i+1, step.Label()), func() {
+			p.Select(i)
+		})
+		if i == p.selected {
+			name.Importance = widget.HighImportance
+		}
+
+		up := widget.NewButtonWithIcon("", theme.MoveUpIcon(), func() {
+			p.move(i, i-1)
+		})
+		down := widget.NewButtonWithIcon("", theme.MoveDownIcon(), func() {
+			p.move(i, i+1)
+		})
+		remove := widget.NewButtonWithIcon("", theme.DeleteIcon(), func() {
+			p.remove(i)
+		})
+		if i == 0 {
+			up.Disable()
+		}
+		if i == p.stack.Len()-1 {
+			down.Disable()
+		}
+
+		p.container.Add(container.NewBorder(nil, nil, enabled, container.NewHBox(up, down, remove), name))
+	}
+}
+
+func (p *StackPanel) Select(i int) {
+	if i < 0 || i >= p.stack.Len() {
+		i = -1
+	}
+	p.selected = i
+	p.Refresh()
+	if p.onSelect != nil {
+		p.onSelect(p.Selected())
+	}
+}
+
+func (p *StackPanel) SelectedIndex() int {
+	return p.selected
+}
+
+func (p *StackPanel) Selected() *pipeline.Step {
+	if p.selected < 0 || p.selected >= p.stack.Len() {
+		return nil
+	}
+	return p.stack.Steps[p.selected]
+}
+
+func (p *StackPanel) move(from, to int) {
+	if to < 0 || to >= p.stack.Len() {
+		return
+	}
+	label := "Move " + p.stack.Steps[from].Label()
+	p.stack.Move(from, to)
+	if p.selected == from {
+		p.selected = to
+	} else if p.selected == to {
+		p.selected = from
+	}
+	p.Refresh()
+	p.changed(label)
+}
+
+func (p *StackPanel) remove(i int) {
+	step := p.stack.Steps[i]
+	label := "Remove " + step.Label()
+	p.stack.Remove(i)
+	if p.onRemove != nil {
+		p.onRemove(step)
+	}
+	switch {
+	case p.selected == i:
+		p.Select(-1)
+	case p.selected > i:
+		p.selected--
+		p.Refresh()
+	default:
+		p.Refresh()
+	}
+	p.changed(label)
+}
+
+func (p *StackPanel) changed(label string) {
+	if p.onChange != nil {
+		p.onChange(label)
+	}
+}
+
+func (p *StackPanel) GetContainer() fyne.CanvasObject {
+	return p.container
+}
+
+func (p *StackPanel) SetOnChange(callback func(label string)) {
+	p.onChange = callback
+}
+
+func (p *StackPanel) SetOnSelect(callback func(step *pipeline.Step)) {
+	p.onSelect = callback
+}
+
+// SetOnRemove is called with a removed step before the change is reported.
+func (p *StackPanel) SetOnRemove(callback func(step *pipeline.Step)) {
+	p.onRemove = callback
+}
@@ -3,272 +3,434 @@ package gui
 import (
 	"image"
 	"image-filter-editor/internal/filters"
+	"image-filter-editor/internal/history"
+	"image-filter-editor/internal/kernels"
+	"image-filter-editor/internal/pipeline"
 	"image-filter-editor/internal/utils"
 
+	"fmt"
 	"image/color"
 	"image/png"
+	"io"
+	"slices"
+	"strings"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/canvas"
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/driver/desktop"
+	"fyne.io/fyne/v2/storage"
 	"fyne.io/fyne/v2/widget"
 )
+
 type MainWindow struct {
-	window     fyne.Window
-	image      *canvas.Image
-	currentImg *image.RGBA
-	origImg    image.Image
+	window        fyne.Window
+	image         *canvas.Image
+	currentImg    *image.RGBA
+	origImg       image.Image
+	baseImg       *image.RGBA
 	filterCanvas  *canvas.Rectangle
 	filterOverlay *FilterOverlay
 	filterPoints  []filters.Point
+	stack         *pipeline.Stack
+	stackPanel    *StackPanel
+	history       *history.History
+	historyPanel  *HistoryPanel
+	kernelLib     *kernels.Library
+	kernelLibErr  error
+	kernelEditor  *KernelEditor
+	userKernels   *fyne.Container
 }
 
-
 func NewMainWindow(app fyne.App) *MainWindow {
-    w := &MainWindow{
-        window: app.NewWindow("Image Filtering App"),
-				filterPoints: []filters.Point{ 
-					{X: 0, Y: 0},
-					{X: 255, Y: 255},
-			},
-    }
-
-    w.image = canvas.NewImageFromImage(nil)
-    w.image.FillMode = canvas.ImageFillOriginal
-    w.image.SetMinSize(fyne.NewSize(200, 500))
-
-    scroll := container.NewScroll(w.image)
-    scroll.SetMinSize(fyne.NewSize(800, 600))
-
-    buttons := w.createButtons()
-    
-    w.window.Resize(fyne.NewSize(1500, 600))
-
-		 w.filterCanvas = canvas.NewRectangle(color.White)
-		 w.filterCanvas.Resize(fyne.NewSize(256, 256))
-		 
-		 w.filterOverlay = NewFilterOverlay()
-    w.filterOverlay.SetOnUpdate(func(param string, value float64) {
-        if w.currentImg == nil {
-            return
-        }
-        
-        switch param {
-        case "brightness":
-            w.currentImg = filters.BrightnessCorrection(w.currentImg, int(value))
-        case "contrast":
-            w.currentImg = filters.ContrastEnhancement(w.currentImg, value)
-        case "gamma":
-            w.currentImg = filters.GammaCorrection(w.currentImg, value)
-				case "grayscale":
-            w.currentImg = filters.ToGrayscale(w.currentImg)
-        case "dither":
-            mapSize := int(w.filterOverlay.GetValue("dither_size"))
-            levels := int(value)
-            w.currentImg = filters.OrderedDithering(w.currentImg, mapSize, levels)
-        case "quantize":
-            numColors := int(value)
-            w.currentImg = filters.PopularityQuantization(w.currentImg, numColors)
-        }
-        
-        w.image.Image = w.currentImg
-        w.image.Refresh()
-    })
-
-    content := container.NewHSplit(
-        container.NewVBox(buttons, scroll),
-        w.filterOverlay.GetContainer(),
-    )
-
-    w.window.SetContent(content)
-    return w
+	w := &MainWindow{
+		window:  app.NewWindow("Image Filtering App"),
+		stack:   &pipeline.Stack{},
+		history: history.New(history.DefaultMaxEntries, history.DefaultMaxBytes),
+		filterPoints: []filters.Point{
+			{X: 0, Y: 0},
+			{X: 255, Y: 255},
+		},
+	}
+
+	if path, err := kernels.DefaultPath(); err != nil {
+		w.kernelLibErr = err
+	} else {
+		w.kernelLib, w.kernelLibErr = kernels.Load(path)
+	}
+
+	w.image = canvas.NewImageFromImage(nil)
+	w.image.FillMode = canvas.ImageFillOriginal
+	w.image.SetMinSize(fyne.NewSize(200, 500))
+
+	scroll := container.NewScroll(w.image)
+	scroll.SetMinSize(fyne.NewSize(800, 600))
+
+	buttons := w.createButtons()
+
+	w.window.Resize(fyne.NewSize(1500, 600))
+
+	w.filterCanvas = canvas.NewRectangle(color.White)
+	w.filterCanvas.Resize(fyne.NewSize(256, 256))
+
+	w.filterOverlay = NewFilterOverlay()
+	w.filterOverlay.SetOnUpdate(w.render)
+	w.filterOverlay.SetOnCommit(w.record)
+
+	w.stackPanel = NewStackPanel(w.stack)
+	w.stackPanel.SetOnSelect(w.filterOverlay.ShowStep)
+	w.stackPanel.SetOnRemove(func(step *pipeline.Step) {
+		// Closing discards the editor's pending edits, which would
+		// otherwise be recorded for a step that no longer exists.
+		if w.kernelEditor != nil && w.kernelEditor.step == step {
+			w.closeKernelEditor()
+		}
+	})
+	w.stackPanel.SetOnChange(func(label string) {
+		w.render()
+		w.record(label)
+	})
+
+	w.history.Reset("Start", w.stack, nil)
+	w.historyPanel = NewHistoryPanel(w.history)
+	w.historyPanel.SetOnJump(w.jump)
+
+	sidePanel := container.NewVBox(
+		w.stackPanel.GetContainer(),
+		widget.NewSeparator(),
+		w.filterOverlay.GetContainer(),
+		widget.NewSeparator(),
+		w.historyPanel.GetContainer(),
+	)
+
+	content := container.NewHSplit(
+		container.NewVBox(buttons, scroll),
+		container.NewVScroll(sidePanel),
+	)
+
+	w.window.SetContent(content)
+
+	undo := &desktop.CustomShortcut{KeyName: fyne.KeyZ, Modifier: fyne.KeyModifierShortcutDefault}
+	redo := &desktop.CustomShortcut{KeyName: fyne.KeyZ, Modifier: fyne.KeyModifierShortcutDefault | fyne.KeyModifierShift}
+	w.window.Canvas().AddShortcut(undo, func(fyne.Shortcut) { w.undo() })
+	w.window.Canvas().AddShortcut(redo, func(fyne.Shortcut) { w.redo() })
+
+	if w.kernelLibErr != nil {
+		dialog.ShowError(fmt.Errorf("kernel library: %w", w.kernelLibErr), w.window)
+	}
+
+	return w
 }
 
 func (w *MainWindow) createButtons() *fyne.Container {
 	loadBtn := widget.NewButton("Load Image", func() {
-			loadImage(w)
+		loadImage(w)
 	})
 
 	saveBtn := widget.NewButton("Save", func() {
-			if w.currentImg != nil {
-					saveImage(w)
-			}
+		if w.currentImg != nil {
+			saveImage(w)
+		}
 	})
 
 	resetBtn := widget.NewButton("Reset", func() {
-			if w.origImg != nil {
-					w.currentImg = utils.ToRGBA(w.origImg)
-					w.image.Image = w.currentImg
-					w.image.Refresh()
-			}
+		w.closeKernelEditor()
+		w.stack.Clear()
+		w.stackPanel.Select(-1)
+		w.render()
+		w.record("Reset")
 	})
 
-	invertBtn := widget.NewButton("Invert", func() {
-			if w.currentImg != nil {
-					w.currentImg = filters.InvertImage(w.currentImg)
-					w.image.Image = w.currentImg
-					w.image.Refresh()
-			}
+	savePresetBtn := widget.NewButton("Save Preset", func() {
+		savePreset(w)
 	})
 
-	brightnessBtn := widget.NewButton("Brightness", func() {
-			if w.currentImg != nil {
-					w.currentImg = filters.BrightnessCorrection(w.currentImg, filters.BRIGHTNESS_FACTOR)
-					w.image.Image = w.currentImg
-					w.image.Refresh()
-			}
+	loadPresetBtn := widget.NewButton("Load Preset", func() {
+		loadPreset(w)
 	})
 
-	contrastBtn := widget.NewButton("Contrast", func() {
-			if w.currentImg != nil {
-					w.currentImg = filters.ContrastEnhancement(w.currentImg, filters.CONTRAST_FACTOR)
-					w.image.Image = w.currentImg
-					w.image.Refresh()
+	undoBtn := widget.NewButton("Undo", w.undo)
+	redoBtn := widget.NewButton("Redo", w.redo)
+
+	// Every row wraps onto more lines when the window is narrow, with
+	// cells as wide as the widest button so that the columns line up.
+	groups := [][]fyne.CanvasObject{{loadBtn, saveBtn, resetBtn, undoBtn, redoBtn, savePresetBtn, loadPresetBtn}}
+	kernelRow := 0
+	for _, category := range filters.Categories() {
+		var group []fyne.CanvasObject
+		for _, f := range filters.All() {
+			if f.Category() != category {
+				continue
 			}
-	})
+			f := f
+			group = append(group, widget.NewButton(f.Label(), func() {
+				w.addStep(f)
+			}))
+		}
+		if category == "Convolution" {
+			group = append(group, widget.NewButton("Kernel Editor...", w.openKernelEditor))
+			kernelRow = len(groups)
+		}
+		groups = append(groups, group)
+	}
 
-	gammaBtn := widget.NewButton("Gamma", func() {
-			if w.currentImg != nil {
-					w.currentImg = filters.GammaCorrection(w.currentImg, filters.GAMMA_FACTOR)
-					w.image.Image = w.currentImg
-					w.image.Refresh()
-			}
-	})
+	var cell fyne.Size
+	for _, group := range groups {
+		for _, b := range group {
+			cell = cell.Max(b.MinSize())
+		}
+	}
+	var rows []fyne.CanvasObject
+	for _, group := range groups {
+		rows = append(rows, container.NewGridWrap(cell, group...))
+	}
+	// Library kernels get a row of their own below the Convolution one.
+	w.userKernels = container.NewGridWrap(cell)
+	w.refreshUserKernels()
+	rows = slices.Insert(rows, kernelRow+1, fyne.CanvasObject(w.userKernels))
+
+	return container.NewVBox(rows...)
+}
 
-	blurBtn := widget.NewButton("Blur", func() {
-			if w.currentImg != nil {
-					w.currentImg = filters.ApplyConvolution(w.currentImg, filters.BLUR_KERNEL)
-					w.image.Image = w.currentImg
-					w.image.Refresh()
-			}
-	})
+func (w *MainWindow) addStep(f filters.Filter) {
+	if _, err := w.stack.Add(f.Name(), filters.Defaults(f)); err != nil {
+		dialog.ShowError(err, w.window)
+		return
+	}
+	w.stackPanel.Select(w.stack.Len() - 1)
+	w.render()
+	w.record("Add " + f.Label())
+}
 
-	gaussianBtn := widget.NewButton("Gaussian", func() {
-			if w.currentImg != nil {
-					w.currentImg = filters.ApplyConvolution(w.currentImg, filters.GAUSSIAN_KERNEL)
-					w.image.Image = w.currentImg
-					w.image.Refresh()
-			}
-	})
+// addKernel adds a Custom Kernel step with a kernel from the library.
+func (w *MainWindow) addKernel(name string, k filters.Kernel) {
+	if _, err := w.stack.Add("kernel", filters.Params{"kernel": k}); err != nil {
+		dialog.ShowError(err, w.window)
+		return
+	}
+	w.stackPanel.Select(w.stack.Len() - 1)
+	w.render()
+	w.record("Add " + name)
+}
 
-	sharpenBtn := widget.NewButton("Sharpen", func() {
-			if w.currentImg != nil {
-					w.currentImg = filters.ApplyConvolution(w.currentImg, filters.SHARPEN_KERNEL)
-					w.image.Image = w.currentImg
-					w.image.Refresh()
-			}
-	})
+// refreshUserKernels lists the library kernels next to the built-in ones.
+func (w *MainWindow) refreshUserKernels() {
+	w.userKernels.RemoveAll()
+	if w.kernelLib == nil {
+		return
+	}
+	for _, e := range w.kernelLib.Entries() {
+		e := e
+		w.userKernels.Add(widget.NewButton(e.Name, func() {
+			w.addKernel(e.Name, e.Kernel)
+		}))
+	}
+}
 
-	edgeBtn := widget.NewButton("Edge Detect", func() {
-			if w.currentImg != nil {
-					w.currentImg = filters.ApplyConvolution(w.currentImg, filters.EDGE_DETECT_KERNEL)
-					w.image.Image = w.currentImg
-					w.image.Refresh()
-			}
+// openKernelEditor edits the selected Custom Kernel step, or adds one if
+// another kind of step is selected.
+func (w *MainWindow) openKernelEditor() {
+	step := w.stackPanel.Selected()
+	if step == nil || step.Filter != "kernel" {
+		f, _ := filters.Lookup("kernel")
+		w.addStep(f)
+		step = w.stackPanel.Selected()
+	}
+	w.closeKernelEditor()
+
+	w.kernelEditor = NewKernelEditor(fyne.CurrentApp(), step, w.kernelLib)
+	w.kernelEditor.SetOnUpdate(w.render)
+	w.kernelEditor.SetOnCommit(func(label string) {
+		w.filterOverlay.ShowStep(w.stackPanel.Selected())
+		w.record(label)
 	})
+	w.kernelEditor.SetOnLibraryChange(w.refreshUserKernels)
+	w.kernelEditor.Show()
+}
 
-	embossBtn := widget.NewButton("Emboss", func() {
-			if w.currentImg != nil {
-					w.currentImg = filters.ApplyConvolution(w.currentImg, filters.EMBOSS_KERNEL)
-					w.image.Image = w.currentImg
-					w.image.Refresh()
-			}
-	})
+func (w *MainWindow) closeKernelEditor() {
+	if w.kernelEditor != nil {
+		w.kernelEditor.Close()
+		w.kernelEditor = nil
+	}
+}
 
-	dilateBtn := widget.NewButton("Dilation", func() {
-			if w.currentImg != nil {
-					w.currentImg = filters.DilateImage(w.currentImg)
-					w.image.Image = w.currentImg
-					w.image.Refresh()
-			}
-	})
+func (w *MainWindow) record(label string) {
+	w.history.Push(label, w.stack, w.currentImg)
+	w.historyPanel.Refresh()
+}
 
-	erodeBtn := widget.NewButton("Erosion", func() {
-			if w.currentImg != nil {
-					w.currentImg = filters.ErodeImage(w.currentImg)
-					w.image.Image = w.currentImg
-					w.image.Refresh()
-			}
-	})
+func (w *MainWindow) undo() {
+	if e, ok := w.history.Undo(); ok {
+		w.restore(e)
+	}
+}
 
-	ycbcrBtn := widget.NewButton("YCbCr + Dithering", func() {
-		if w.currentImg != nil {
-				w.currentImg = filters.YCbCrDithering(w.currentImg)
-				w.image.Image = w.currentImg
-				w.image.Refresh()
-		}
-})
+func (w *MainWindow) redo() {
+	if e, ok := w.history.Redo(); ok {
+		w.restore(e)
+	}
+}
 
+func (w *MainWindow) jump(i int) {
+	if e, ok := w.history.Jump(i); ok {
+		w.restore(e)
+	}
+}
 
+func (w *MainWindow) restore(e *history.Entry) {
+	w.closeKernelEditor()
+	w.stack.Steps = e.Stack.Clone().Steps
+	w.stackPanel.Select(w.stackPanel.SelectedIndex())
+
+	if img := e.Image(); img != nil {
+		w.currentImg = img
+		w.image.Image = w.currentImg
+		w.image.Refresh()
+	} else {
+		w.render()
+		if w.currentImg != nil {
+			w.history.SetImage(w.currentImg)
+		}
+	}
+	w.historyPanel.Refresh()
+}
 
-	return container.NewVBox(
-			container.NewHBox(loadBtn, saveBtn, resetBtn),
-			container.NewHBox(invertBtn, brightnessBtn, contrastBtn, gammaBtn),
-			container.NewHBox(blurBtn, gaussianBtn, sharpenBtn, edgeBtn, embossBtn),
-			container.NewHBox(dilateBtn, erodeBtn, ycbcrBtn),
-	)
+// render re-evaluates the whole stack from the original image.
+func (w *MainWindow) render() {
+	if w.baseImg == nil {
+		return
+	}
+	img, err := w.stack.Render(w.baseImg)
+	if err != nil {
+		dialog.ShowError(err, w.window)
+		return
+	}
+	w.currentImg = img
+	w.image.Image = w.currentImg
+	w.image.Refresh()
 }
 
 func (w *MainWindow) Show() {
-    w.window.ShowAndRun()
+	w.window.ShowAndRun()
 }
 
 func loadImage(w *MainWindow) {
 	dialog.ShowFileOpen(func(reader fyne.URIReadCloser, err error) {
-			if err != nil {
-					dialog.ShowError(err, w.window)
-					return
-			}
-			if reader == nil {
-					return
-			}
+		if err != nil {
+			dialog.ShowError(err, w.window)
+			return
+		}
+		if reader == nil {
+			return
+		}
 
-			img, _, err := image.Decode(reader)
-			if err != nil {
-					dialog.ShowError(err, w.window)
-					return
-			}
+		img, _, err := image.Decode(reader)
+		if err != nil {
+			dialog.ShowError(err, w.window)
+			return
+		}
 
-			w.origImg = img
-			w.currentImg = utils.ToRGBA(img)
-			w.image.Image = w.currentImg
-			w.image.Refresh()
+		w.origImg = img
+		w.baseImg = utils.ToRGBA(img)
+		w.render()
+		w.history.Reset("Open image", w.stack, w.currentImg)
+		w.historyPanel.Refresh()
 
-			bounds := w.currentImg.Bounds()
-			imgWidth := float32(bounds.Dx())
-			imgHeight := float32(bounds.Dy())
+		bounds := w.baseImg.Bounds()
+		imgWidth := float32(bounds.Dx())
+		imgHeight := float32(bounds.Dy())
 
-			screenSize := w.window.Canvas().Size()
-			maxWidth := screenSize.Width
-			maxHeight := screenSize.Height
+		screenSize := w.window.Canvas().Size()
+		maxWidth := screenSize.Width
+		maxHeight := screenSize.Height
 
-			if imgWidth > maxWidth {
-					imgWidth = maxWidth
-			}
-			if imgHeight+100 > maxHeight {
-					imgHeight = maxHeight - 100
-			}
+		if imgWidth > maxWidth {
+			imgWidth = maxWidth
+		}
+		if imgHeight+100 > maxHeight {
+			imgHeight = maxHeight - 100
+		}
 
-			w.window.Resize(fyne.NewSize(imgWidth, imgHeight+100))
+		w.window.Resize(fyne.NewSize(imgWidth, imgHeight+100))
 	}, w.window)
 }
 
 func saveImage(w *MainWindow) {
 	dialog.ShowFileSave(func(writer fyne.URIWriteCloser, err error) {
-			if err != nil {
-					dialog.ShowError(err, w.window)
-					return
-			}
-			if writer == nil {
-					return
-			}
-			
-			err = png.Encode(writer, w.currentImg)
-			if err != nil {
-					dialog.ShowError(err, w.window)
-					return
-			}
-			writer.Close()
+		if err != nil {
+			dialog.ShowError(err, w.window)
+			return
+		}
+		if writer == nil {
+			return
+		}
+
+		err = png.Encode(writer, w.currentImg)
+		if err != nil {
+			dialog.ShowError(err, w.window)
+			return
+		}
+		writer.Close()
+	}, w.window)
+}
+
+func savePreset(w *MainWindow) {
+	dialog.ShowFileSave(func(writer fyne.URIWriteCloser, err error) {
+		if err != nil {
+			dialog.ShowError(err, w.window)
+			return
+		}
+		if writer == nil {
+			return
+		}
+		defer writer.Close()
+
+		name := strings.TrimSuffix(writer.URI().Name(), writer.URI().Extension())
+		data, err := pipeline.MarshalPreset(pipeline.NewPreset(name, w.stack), pipeline.IsYAML(writer.URI().Name()))
+		if err == nil {
+			_, err = writer.Write(data)
+		}
+		if err != nil {
+			dialog.ShowError(err, w.window)
+		}
 	}, w.window)
-}
\ No newline at end of file
+}
+
+func loadPreset(w *MainWindow) {
+	open := dialog.NewFileOpen(func(reader fyne.URIReadCloser, err error) {
+		if err != nil {
+			dialog.ShowError(err, w.window)
+			return
+		}
+		if reader == nil {
+			return
+		}
+		defer reader.Close()
+
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			dialog.ShowError(err, w.window)
+			return
+		}
+		preset, err := pipeline.UnmarshalPreset(data, pipeline.IsYAML(reader.URI().Name()))
+		if err != nil {
+			dialog.ShowError(err, w.window)
+			return
+		}
+		stack, err := preset.Stack()
+		if err != nil {
+			dialog.ShowError(err, w.window)
+			return
+		}
+
+		w.closeKernelEditor()
+		w.stack.Steps = stack.Steps
+		w.stackPanel.Select(-1)
+		w.render()
+		w.record("Load preset " + reader.URI().Name())
+	}, w.window)
+	open.SetFilter(storage.NewExtensionFileFilter([]string{".json", ".yaml", ".yml"}))
+	open.Show()
+}
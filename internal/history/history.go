@@ -0,0 +1,137 @@
+package history
+
+import (
+	"image"
+
+	"image-filter-editor/internal/pipeline"
+)
+
+const (
+	DefaultMaxEntries = 100
+	DefaultMaxBytes   = 512 << 20
+)
+
+// Entry is a snapshot of the filter stack after an edit. The rendered image
+// is only a cache: it may be dropped to stay under the memory cap and is
+// then re-rendered from Stack.
+type Entry struct {
+	Label string
+	Stack *pipeline.Stack
+	image *image.RGBA
+}
+
+func (e *Entry) Image() *image.RGBA {
+	return e.image
+}
+
+// History is a bounded undo/redo list of stack states.
+type History struct {
+	entries    []*Entry
+	current    int
+	maxEntries int
+	maxBytes   int64
+}
+
+func New(maxEntries int, maxBytes int64) *History {
+	if maxEntries < 1 {
+		maxEntries = DefaultMaxEntries
+	}
+	return &History{current: -1, maxEntries: maxEntries, maxBytes: maxBytes}
+}
+
+// Push records a new state after the current one, discarding any states
+// that could have been redone.
+func (h *History) Push(label string, stack *pipeline.Stack, img *image.RGBA) {
+	h.entries = append(h.entries[:h.current+1], &Entry{Label: label, Stack: stack.Clone(), image: img})
+	if len(h.entries) > h.maxEntries {
+		h.entries = h.entries[len(h.entries)-h.maxEntries:]
+	}
+	h.current = len(h.entries) - 1
+	h.trim()
+}
+
+// Reset drops all states and starts over with a single one.
+func (h *History) Reset(label string, stack *pipeline.Stack, img *image.RGBA) {
+	h.entries = nil
+	h.current = -1
+	h.Push(label, stack, img)
+}
+
+func (h *History) CanUndo() bool {
+	return h.current > 0
+}
+
+func (h *History) CanRedo() bool {
+	return h.current < len(h.entries)-1
+}
+
+func (h *History) Undo() (*Entry, bool) {
+	return h.Jump(h.current - 1)
+}
+
+func (h *History) Redo() (*Entry, bool) {
+	return h.Jump(h.current + 1)
+}
+
+func (h *History) Jump(i int) (*Entry, bool) {
+	if i < 0 || i >= len(h.entries) {
+		return nil, false
+	}
+	h.current = i
+	return h.entries[i], true
+}
+
+// SetImage caches a rendered image for the current entry, for example
+// after it had to be re-rendered because its cache was evicted.
+func (h *History) SetImage(img *image.RGBA) {
+	if h.current < 0 {
+		return
+	}
+	h.entries[h.current].image = img
+	h.trim()
+}
+
+func (h *History) Entries() []*Entry {
+	return h.entries
+}
+
+func (h *History) Current() int {
+	return h.current
+}
+
+// trim evicts cached images, farthest from the current entry first,
+// until the total size fits into maxBytes. The current image is never evicted.
+func (h *History) trim() {
+	if h.maxBytes <= 0 {
+		return
+	}
+	var total int64
+	for _, e := range h.entries {
+		if e.image != nil {
+			total += int64(len(e.image.Pix))
+		}
+	}
+	for total > h.maxBytes {
+		far := -1
+		for i, e := range h.entries {
+			if e.image == nil || i == h.current {
+				continue
+			}
+			if far < 0 || abs(i-h.current) > abs(far-h.current) {
+				far = i
+			}
+		}
+		if far < 0 {
+			return
+		}
+		total -= int64(len(h.entries[far].image.Pix))
+		h.entries[far].image = nil
+	}
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,125 @@
+// Package kernels stores the user's named convolution kernels.
+package kernels
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"image-filter-editor/internal/filters"
+)
+
+// LibraryVersion is the kernel library file format version.
+const LibraryVersion = 1
+
+type Entry struct {
+	Name   string         `json:"name"`
+	Kernel filters.Kernel `json:"kernel"`
+}
+
+// Library is a set of named kernels persisted as a JSON file.
+type Library struct {
+	path    string
+	entries []Entry
+}
+
+type libraryFile struct {
+	Version int     `json:"version"`
+	Kernels []Entry `json:"kernels"`
+}
+
+// DefaultPath returns the library location in the user's config directory.
+func DefaultPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "imagefilter", "kernels.json"), nil
+}
+
+// Load reads the library at path. A missing file is an empty library.
+func Load(path string) (*Library, error) {
+	l := &Library{path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var f libraryFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if f.Version > LibraryVersion {
+		return nil, fmt.Errorf("%s: library version %d is newer than supported version %d", path, f.Version, LibraryVersion)
+	}
+	for _, e := range f.Kernels {
+		if err := e.Kernel.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: kernel %q: %w", path, e.Name, err)
+		}
+	}
+	l.entries = f.Kernels
+	l.sort()
+	return l, nil
+}
+
+// Entries returns the kernels sorted by name.
+func (l *Library) Entries() []Entry {
+	entries := make([]Entry, len(l.entries))
+	for i, e := range l.entries {
+		entries[i] = Entry{e.Name, e.Kernel.Clone()}
+	}
+	return entries
+}
+
+// Put adds a kernel or replaces the one with the same name.
+func (l *Library) Put(name string, k filters.Kernel) error {
+	if name == "" {
+		return errors.New("kernel name is empty")
+	}
+	if err := k.Validate(); err != nil {
+		return err
+	}
+	for i := range l.entries {
+		if l.entries[i].Name == name {
+			l.entries[i].Kernel = k.Clone()
+			return nil
+		}
+	}
+	l.entries = append(l.entries, Entry{name, k.Clone()})
+	l.sort()
+	return nil
+}
+
+func (l *Library) Remove(name string) {
+	for i := range l.entries {
+		if l.entries[i].Name == name {
+			l.entries = append(l.entries[:i], l.entries[i+1:]...)
+			return
+		}
+	}
+}
+
+// Save writes the library back to the file it was loaded from.
+func (l *Library) Save() error {
+	data, err := json.MarshalIndent(libraryFile{Version: LibraryVersion, Kernels: l.entries}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(l.path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(l.path, data, 0o644)
+}
+
+func (l *Library) sort() {
+	sort.Slice(l.entries, func(i, j int) bool {
+		return l.entries[i].Name < l.entries[j].Name
+	})
+}
@@ -0,0 +1,114 @@
+package pipeline
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"image-filter-editor/internal/filters"
+)
+
+// ParseStep builds a step from a command line spec. Three forms are accepted:
+//
+//	gaussian                  filter with default parameters
+//	gamma=1.8                 sets the filter's first parameter
+//	dither:levels=4,size=4    sets named parameters
+func ParseStep(spec string) (*Step, error) {
+	spec = strings.TrimSpace(spec)
+	name, args, named := strings.Cut(spec, ":")
+	var value string
+	var positional bool
+	if !named {
+		name, value, positional = strings.Cut(spec, "=")
+	}
+
+	f, ok := filters.Lookup(strings.TrimSpace(name))
+	if !ok {
+		return nil, fmt.Errorf("unknown filter %q", name)
+	}
+
+	p := filters.Params{}
+	if positional {
+		if len(f.Params()) == 0 {
+			return nil, fmt.Errorf("filter %q takes no parameters", f.Name())
+		}
+		v, err := ParseParam(f.Params()[0], value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name(), err)
+		}
+		p[f.Params()[0].Name] = v
+	}
+	if named {
+		for _, kv := range strings.Split(args, ",") {
+			key, val, ok := strings.Cut(kv, "=")
+			if !ok {
+				return nil, fmt.Errorf("%s: expected key=value, got %q", f.Name(), kv)
+			}
+			param, ok := findParam(f, strings.TrimSpace(key))
+			if !ok {
+				return nil, fmt.Errorf("%s: unknown parameter %q", f.Name(), key)
+			}
+			v, err := ParseParam(param, val)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", f.Name(), err)
+			}
+			p[param.Name] = v
+		}
+	}
+
+	return NewStep(f.Name(), p)
+}
+
+// ParseParam converts a textual value into the type expected by param.
+func ParseParam(param filters.Param, s string) (any, error) {
+	s = strings.TrimSpace(s)
+	switch param.Kind {
+	case filters.ParamInt, filters.ParamFloat:
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parameter %q: %q is not a number", param.Name, s)
+		}
+		if param.Min < param.Max && (v < param.Min || v > param.Max) {
+			return nil, fmt.Errorf("parameter %q: %v is outside [%v, %v]", param.Name, v, param.Min, param.Max)
+		}
+		return v, nil
+	case filters.ParamBool:
+		v, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("parameter %q: %q is not a boolean", param.Name, s)
+		}
+		return v, nil
+	case filters.ParamChoice:
+		for _, c := range param.Choices {
+			if c == s {
+				return s, nil
+			}
+		}
+		return nil, fmt.Errorf("parameter %q: %q is not one of %s", param.Name, s, strings.Join(param.Choices, ", "))
+	case filters.ParamColor:
+		c, err := filters.ParseColor(s)
+		if err != nil {
+			return nil, fmt.Errorf("parameter %q: %w", param.Name, err)
+		}
+		return c, nil
+	case filters.ParamKernel:
+		// Kernels are read from a file, e.g. custom=@map.txt.
+		if path, ok := strings.CutPrefix(s, "@"); ok {
+			k, err := filters.ReadKernel(path)
+			if err != nil {
+				return nil, fmt.Errorf("parameter %q: %w", param.Name, err)
+			}
+			return k, nil
+		}
+	}
+	return nil, fmt.Errorf("parameter %q cannot be set from the command line", param.Name)
+}
+
+func findParam(f filters.Filter, name string) (filters.Param, bool) {
+	for _, p := range f.Params() {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return filters.Param{}, false
+}
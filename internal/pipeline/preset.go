@@ -0,0 +1,193 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"image-filter-editor/internal/filters"
+
+	"gopkg.in/yaml.v3"
+)
+
+// PresetVersion is the preset file format version written by SavePreset.
+const PresetVersion = 1
+
+// Preset is the on-disk form of a filter stack, stored as JSON or YAML.
+type Preset struct {
+	Version int          `json:"version" yaml:"version"`
+	Name    string       `json:"name,omitempty" yaml:"name,omitempty"`
+	Steps   []PresetStep `json:"steps" yaml:"steps"`
+}
+
+type PresetStep struct {
+	Filter  string         `json:"filter" yaml:"filter"`
+	Enabled *bool          `json:"enabled,omitempty" yaml:"enabled,omitempty"`
+	Params  map[string]any `json:"params,omitempty" yaml:"params,omitempty"`
+}
+
+func NewPreset(name string, s *Stack) *Preset {
+	p := &Preset{Version: PresetVersion, Name: name}
+	for _, step := range s.Steps {
+		ps := PresetStep{Filter: step.Filter, Params: map[string]any(step.Params.Clone())}
+		for k, v := range ps.Params {
+			if c, ok := v.(color.RGBA); ok {
+				ps.Params[k] = filters.FormatColor(c)
+			}
+		}
+		if !step.Enabled {
+			disabled := false
+			ps.Enabled = &disabled
+		}
+		p.Steps = append(p.Steps, ps)
+	}
+	return p
+}
+
+// Stack converts the preset back into a stack, validating every filter
+// name and parameter against the registry.
+func (p *Preset) Stack() (*Stack, error) {
+	if p.Version < 1 {
+		return nil, fmt.Errorf("preset has no version")
+	}
+	if p.Version > PresetVersion {
+		return nil, fmt.Errorf("preset version %d is newer than supported version %d", p.Version, PresetVersion)
+	}
+
+	s := &Stack{}
+	for i, ps := range p.Steps {
+		f, ok := filters.Lookup(ps.Filter)
+		if !ok {
+			return nil, fmt.Errorf("step %d: unknown filter %q", i+1, ps.Filter)
+		}
+		params := filters.Params{}
+		for key, raw := range ps.Params {
+			param, ok := findParam(f, key)
+			if !ok {
+				return nil, fmt.Errorf("step %d (%s): unknown parameter %q", i+1, f.Name(), key)
+			}
+			v, err := decodeParam(param, raw)
+			if err != nil {
+				return nil, fmt.Errorf("step %d (%s): %w", i+1, f.Name(), err)
+			}
+			params[key] = v
+		}
+		step, err := NewStep(f.Name(), params)
+		if err != nil {
+			return nil, err
+		}
+		if ps.Enabled != nil {
+			step.Enabled = *ps.Enabled
+		}
+		s.Steps = append(s.Steps, step)
+	}
+	return s, nil
+}
+
+// decodeParam converts a generic JSON/YAML value into the Go type used
+// by filters.Params for the given parameter kind.
+func decodeParam(param filters.Param, raw any) (any, error) {
+	switch param.Kind {
+	case filters.ParamInt, filters.ParamFloat:
+		v, ok := filters.DecodeFloat(raw)
+		if !ok {
+			return nil, fmt.Errorf("parameter %q: expected a number", param.Name)
+		}
+		return v, nil
+	case filters.ParamBool:
+		v, ok := raw.(bool)
+		if !ok {
+			return nil, fmt.Errorf("parameter %q: expected true or false", param.Name)
+		}
+		return v, nil
+	case filters.ParamChoice, filters.ParamColor:
+		s, ok := raw.(string)
+		if !ok {
+			return nil, fmt.Errorf("parameter %q: expected a string", param.Name)
+		}
+		return ParseParam(param, s)
+	case filters.ParamPoints:
+		list, ok := raw.([]any)
+		if !ok {
+			return nil, fmt.Errorf("parameter %q: expected a list of points", param.Name)
+		}
+		points := make([]filters.Point, 0, len(list))
+		for _, item := range list {
+			pt, ok := toPoint(item)
+			if !ok {
+				return nil, fmt.Errorf("parameter %q: points must be {x, y} or [x, y]", param.Name)
+			}
+			points = append(points, pt)
+		}
+		return points, nil
+	case filters.ParamKernel:
+		k, err := filters.DecodeKernel(raw)
+		if err != nil {
+			return nil, fmt.Errorf("parameter %q: %w", param.Name, err)
+		}
+		return k, nil
+	}
+	return raw, nil
+}
+
+func toPoint(v any) (filters.Point, bool) {
+	if m, ok := v.(map[string]any); ok {
+		x, okX := filters.DecodeFloat(m["x"])
+		y, okY := filters.DecodeFloat(m["y"])
+		return filters.Point{X: x, Y: y}, okX && okY
+	}
+	if xy, ok := filters.DecodeFloats(v); ok && len(xy) == 2 {
+		return filters.Point{X: xy[0], Y: xy[1]}, true
+	}
+	return filters.Point{}, false
+}
+
+// IsYAML reports whether a preset path should be read and written as YAML.
+func IsYAML(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+func MarshalPreset(p *Preset, asYAML bool) ([]byte, error) {
+	if asYAML {
+		return yaml.Marshal(p)
+	}
+	return json.MarshalIndent(p, "", "  ")
+}
+
+func UnmarshalPreset(data []byte, asYAML bool) (*Preset, error) {
+	p := &Preset{}
+	var err error
+	if asYAML {
+		err = yaml.Unmarshal(data, p)
+	} else {
+		err = json.Unmarshal(data, p)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid preset: %w", err)
+	}
+	return p, nil
+}
+
+func LoadPreset(path string) (*Preset, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p, err := UnmarshalPreset(data, IsYAML(path))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return p, nil
+}
+
+func SavePreset(path string, p *Preset) error {
+	data, err := MarshalPreset(p, IsYAML(path))
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}